@@ -2,7 +2,10 @@ module caller-injector-go
 
 go 1.22.4
 
-require github.com/sirupsen/logrus v1.9.3
+require (
+	github.com/sirupsen/logrus v1.9.3
+	injectorsdk v0.0.0
+)
 
 require (
 	github.com/golang/snappy v0.0.4 // indirect
@@ -13,11 +16,18 @@ require (
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
 	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+	google.golang.org/grpc v1.65.0 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
 	go.mongodb.org/mongo-driver v1.17.4
 	golang.org/x/sys v0.23.0 // indirect
 )
+
+replace injectorsdk => ../../injectorsdk
@@ -5,15 +5,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
+
+	"injectorsdk"
 
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var mongoURI = os.Getenv("MONGO_URI")
-var cache = make(map[string]Service)
 
 type Injector struct {
 	logger         *logrus.Logger
@@ -22,6 +24,9 @@ type Injector struct {
 	collectionName string
 	client         *mongo.Client
 	collection     *mongo.Collection
+	// resolver reads descriptors and keeps its cache in sync with the
+	// collection, as the SDK's sdk mode does
+	resolver *injectorsdk.MongoResolver
 }
 
 // Custom CSV Formatter
@@ -54,6 +59,16 @@ func NewInjector() *Injector {
 	}
 
 	injector.connect()
+	resolver, err := injectorsdk.NewMongoResolver(context.TODO(), mongoURI, injectorsdk.Options{
+		CacheTTL:         durationEnv("CACHE_TTL", 10*time.Minute),
+		NegativeCacheTTL: durationEnv("CACHE_NEGATIVE_TTL", 5*time.Second),
+		CacheMaxEntries:  intEnv("CACHE_MAX_ENTRIES", 1000),
+		PollInterval:     durationEnv("WATCH_POLL_INTERVAL", 5*time.Second),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	injector.resolver = resolver
 	return injector
 }
 
@@ -67,10 +82,14 @@ func (i *Injector) connect() {
 	i.collection = i.client.Database(i.dbName).Collection(i.collectionName)
 }
 
+// Service mirrors the injector descriptor, binding specific attributes
+// (Admin, Bucket, ...) are kept inline in the document
 type Service struct {
-	ID             string `bson:"id"`
-	ServiceName    string `bson:"ServiceName"`
-	ServiceAddress string `bson:"ServiceAddress"`
+	ID             string                 `bson:"id"`
+	ServiceName    string                 `bson:"ServiceName"`
+	ServiceAddress string                 `bson:"ServiceAddress"`
+	Kind           string                 `bson:"Kind,omitempty"`
+	Attributes     map[string]interface{} `bson:",inline"`
 }
 
 func (i *Injector) RegisterService(id, name, address string) error {
@@ -87,20 +106,33 @@ func (i *Injector) RegisterService(id, name, address string) error {
 	return nil
 }
 
-func (i *Injector) GetServiceById(id string) (Service, error) {
+// GetServiceById resolves id, from the cache when it can. An unknown id
+// fails with an error matching injectorsdk.ErrNotFound.
+func (i *Injector) GetServiceById(id string) (injectorsdk.Service, error) {
+	return i.resolver.Resolve(context.TODO(), id)
+}
 
-	// Check if the service is in cache
-	if service, found := cache[id]; found {
-		return service, nil
-	}
+// GetServicesByIds resolves several ids with at most one query for the ones
+// not cached. Ids that could not be resolved are reported in the error map.
+func (i *Injector) GetServicesByIds(ids []string) (map[string]injectorsdk.Service, map[string]error) {
+	return i.resolver.ResolveBatch(context.TODO(), ids)
+}
 
-	var service Service
-	err := i.collection.FindOne(context.TODO(), bson.D{{Key: "id", Value: id}}).Decode(&service)
-	if err != nil {
-		return Service{}, err
+// CacheStats reports the resolution cache counters
+func (i *Injector) CacheStats() injectorsdk.CacheStats {
+	return i.resolver.CacheStats()
+}
+
+func durationEnv(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return d
 	}
+	return def
+}
 
-	// Store in cache
-	cache[id] = service
-	return service, nil
+func intEnv(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
 }
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"net/http" // Added for os.Getenv example
 	"time"
+
+	"injectorsdk"
 )
 
 // OPAInput represents the structure of the input required by your Rego policy.
@@ -27,16 +29,16 @@ type ACLResponse struct {
 // OPAService represents the OPA client and its configuration.
 // This is the struct that your "injector service" would ideally return.
 type ACLService struct {
-	serverURL  string
+	endpoints  *injectorsdk.Balancer // replicas of the OPA server
 	httpClient *http.Client
 }
 
 // NewOPAService creates and returns a new OPAService instance.
 // This function mimics what your "injector service" would do.
 // It could take other parameters for configuration (e.g., custom http.Client, timeouts).
-func NewACLService(url string) *ACLService {
+func NewACLService(endpoints *injectorsdk.Balancer) *ACLService {
 	return &ACLService{
-		serverURL:  url,
+		endpoints:  endpoints,
 		httpClient: &http.Client{Timeout: 5 * time.Second}, // Configure client once
 	}
 }
@@ -44,9 +46,12 @@ func NewACLService(url string) *ACLService {
 // EvaluatePolicy sends an authorization request to the OPA server
 // associated with this OPAService instance and returns the boolean decision.
 func (s *ACLService) Authorize(method, userRole string) (bool, error) {
+	serverURL, done := s.endpoints.Pick()
+	defer done()
+
 	// Construct the full URL to your policy endpoint
 	// For your `authz.rego` with `package authz`, the endpoint is /v1/data/authz/allow
-	endpoint := fmt.Sprintf("%s/v1/data/authz/allow", s.serverURL)
+	endpoint := fmt.Sprintf("%s/v1/data/authz/allow", serverURL)
 
 	// Prepare the input data according to your Rego policy's expectations
 	input := ACLInput{}
@@ -71,6 +76,8 @@ func (s *ACLService) Authorize(method, userRole string) (bool, error) {
 	// Send the request
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
+		// Send the next calls to the other replicas for a while
+		s.endpoints.MarkUnhealthy(serverURL)
 		return false, fmt.Errorf("failed to send request to OPA: %w", err)
 	}
 	defer resp.Body.Close()
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,14 +10,12 @@ import (
 	"strconv"
 	"time"
 
+	"injectorsdk"
+
 	"github.com/sirupsen/logrus"
 )
 
-type Service struct {
-	Id             string `json:"id"`
-	ServiceName    string `json:"ServiceName"`
-	ServiceAddress string `json:"ServiceAddress"`
-}
+//go:generate go run injectorsdk/cmd/injectorgen
 
 type Payload struct {
 	Message string `json:"message"`
@@ -24,9 +23,7 @@ type Payload struct {
 
 var logger = logrus.New()
 
-var injectorURL string
-
-var ids []string
+var container *injectorsdk.Container
 
 // Custom CSV Formatter
 type CSVFormatter struct{}
@@ -56,31 +53,17 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 
-	//resp, err := http.Get("http://injector.default.svc.cluster.local/services/hello")
-	resp, err := http.Get(injectorURL + "/services/acl")
+	deps, err := NewDeps(r.Context(), container)
 	if err != nil {
-		http.Error(w, "Failed to reach injector", 500)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		http.Error(w, "Service not found", 404)
+		logger.Infof("Failed to resolve service: %v", err)
+		http.Error(w, "Failed to resolve service", injectorsdk.HTTPStatus(err))
 		return
 	}
 	end := time.Now()
 	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
 
-	var svc Service
-	if err := json.NewDecoder(resp.Body).Decode(&svc); err != nil {
-		http.Error(w, "Invalid response", 500)
-		return
-	}
-
-	acl_service := NewACLService(svc.ServiceAddress)
-
 	start = time.Now()
-	allowed, err := acl_service.Authorize("GET", "reader")
+	allowed, err := deps.ACL().Authorize("GET", "reader")
 	end = time.Now()
 	if err != nil {
 		http.Error(w, "Authorization failed: "+err.Error(), http.StatusInternalServerError)
@@ -102,10 +85,27 @@ func main() {
 	logger.SetLevel(logrus.InfoLevel)    // Log level
 	logger.SetFormatter(&CSVFormatter{}) // Use custom CSV formatter
 
-	// Get env vars
-	injectorURL = os.Getenv("INJECTOR_URL")
-	if injectorURL == "" {
-		injectorURL = "http://injector.default.svc.cluster.local"
+	// Resolution mode and injector client configured from INJECTOR_MODE and friends
+	resolver, err := injectorsdk.NewResolverFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	container = injectorsdk.NewContainer(resolver)
+	injectorsdk.Provide(container, "opa", func(ctx context.Context, svc injectorsdk.Service) (*ACLService, error) {
+		return NewACLService(injectorsdk.NewBalancer(svc)), nil
+	})
+
+	// Resolve everything in dependencies.yaml in one batch before serving,
+	// then fail fast if a dependency is missing or has the wrong type
+	manifest, err := injectorsdk.LoadManifestFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := container.Preload(context.Background(), manifest); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := NewDeps(context.Background(), container); err != nil {
+		log.Fatal(err)
 	}
 
 	http.HandleFunc("/", handler)
@@ -10,17 +10,12 @@ import (
 	"strconv"
 	"time"
 
+	"injectorsdk"
+
 	"github.com/sirupsen/logrus"
 )
 
-type Service struct {
-	Id             string `json:"id"`
-	ServiceName    string `json:"ServiceName"`
-	ServiceAddress string `json:"ServiceAddress"`
-	Admin          string `json:"Admin"`
-	Password       string `json:"Password"`
-	Bucket         string `json:"Bucket"`
-}
+//go:generate go run injectorsdk/cmd/injectorgen
 
 type Payload struct {
 	Message string `json:"message"`
@@ -28,9 +23,7 @@ type Payload struct {
 
 var logger = logrus.New()
 
-var injectorURL string
-
-var ids []string
+var container *injectorsdk.Container
 
 // Custom CSV Formatter
 type CSVFormatter struct{}
@@ -60,35 +53,18 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 
-	//resp, err := http.Get("http://injector.default.svc.cluster.local/services/hello")
-	resp, err := http.Get(injectorURL + "/services/minio")
+	deps, err := NewDeps(r.Context(), container)
 	if err != nil {
-		http.Error(w, "Failed to reach injector", 500)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		http.Error(w, "Service not found", 404)
+		logger.Infof("Failed to resolve service: %v", err)
+		http.Error(w, "Failed to resolve service", injectorsdk.HTTPStatus(err))
 		return
 	}
 	end := time.Now()
 	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
 
-	var svc Service
-	if err := json.NewDecoder(resp.Body).Decode(&svc); err != nil {
-		logger.Error(w, "Invalid response", 500) //////////////////
-		return
-	}
-
 	start = time.Now()
 
-	minio, err := NewMinio(svc.ServiceAddress, svc.Admin, svc.Password, svc.Bucket)
-	if err != nil {
-		logger.Error(w, "Failed to create MinIO client: "+err.Error(), http.StatusInternalServerError) /////////////////
-		return
-	}
-	err = minio.Upload(context.Background(), time.Now().UTC().String()+".txt", []byte("Hello from Go"), "text/plain")
+	err = deps.Storage().Upload(context.Background(), time.Now().UTC().String()+".txt", []byte("Hello from Go"), "text/plain")
 	if err != nil {
 		logger.Fatal("Upload failed:", err) /////////////////////
 	}
@@ -109,10 +85,27 @@ func main() {
 	logger.SetLevel(logrus.InfoLevel)    // Log level
 	logger.SetFormatter(&CSVFormatter{}) // Use custom CSV formatter
 
-	// Get env vars
-	injectorURL = os.Getenv("INJECTOR_URL")
-	if injectorURL == "" {
-		injectorURL = "http://injector.default.svc.cluster.local"
+	// Resolution mode and injector client configured from INJECTOR_MODE and friends
+	resolver, err := injectorsdk.NewResolverFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	container = injectorsdk.NewContainer(resolver)
+	injectorsdk.Provide(container, "minio", func(ctx context.Context, svc injectorsdk.Service) (*MinioService, error) {
+		return NewMinio(svc.ServiceAddress, svc.String("Admin"), svc.String("Password"), svc.String("SessionToken"), svc.String("Bucket"))
+	})
+
+	// Resolve everything in dependencies.yaml in one batch before serving,
+	// then fail fast if a dependency is missing or has the wrong type
+	manifest, err := injectorsdk.LoadManifestFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := container.Preload(context.Background(), manifest); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := NewDeps(context.Background(), container); err != nil {
+		log.Fatal(err)
 	}
 
 	http.HandleFunc("/", handler)
@@ -17,9 +17,9 @@ type MinioService struct {
 }
 
 // New creates and initializes the MinIO client
-func NewMinio(endpoint, accessKey, secretKey, bucketName string) (*MinioService, error) {
+func NewMinio(endpoint, accessKey, secretKey, sessionToken, bucketName string) (*MinioService, error) {
 	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, sessionToken),
 		Secure: false,
 	})
 	if err != nil {
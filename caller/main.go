@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,22 +11,18 @@ import (
 	"strconv"
 	"time"
 
+	"injectorsdk"
+
 	"github.com/sirupsen/logrus"
 )
 
-type Service struct {
-	Id             string `json:"id"`
-	ServiceName    string `json:"ServiceName"`
-	ServiceAddress string `json:"ServiceAddress"`
-}
-
 type Payload struct {
 	Message string `json:"message"`
 }
 
 var logger = logrus.New()
 
-var injectorURL string
+var container *injectorsdk.Container
 
 var ids []string
 
@@ -61,27 +58,15 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 
-	//resp, err := http.Get("http://injector.default.svc.cluster.local/services/hello")
-	resp, err := http.Get(injectorURL + "/services/" + id)
+	_, err = container.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Failed to reach injector", 500)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		http.Error(w, "Service not found", 404)
+		logger.Infof("Failed to resolve service: %v", err)
+		http.Error(w, "Failed to resolve service", injectorsdk.HTTPStatus(err))
 		return
 	}
 	end := time.Now()
 	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
 
-	var svc Service
-	if err := json.NewDecoder(resp.Body).Decode(&svc); err != nil {
-		http.Error(w, "Invalid response", 500)
-		return
-	}
-
 	start = time.Now()
 	// Call the discovered function
 	/*
@@ -109,11 +94,29 @@ func main() {
 	logger.SetLevel(logrus.InfoLevel)    // Log level
 	logger.SetFormatter(&CSVFormatter{}) // Use custom CSV formatter
 
+	// Resolution mode and injector client configured from INJECTOR_MODE and friends
+	resolver, err := injectorsdk.NewResolverFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	container = injectorsdk.NewContainer(resolver)
+	// The hello services are only resolved, their descriptor is the client
+	injectorsdk.Provide(container, "", func(ctx context.Context, svc injectorsdk.Service) (injectorsdk.Service, error) {
+		return svc, nil
+	})
+
+	// Resolve the services in dependencies.yaml in one batch before serving
+	// and pick among them on every invocation
+	manifest, err := injectorsdk.LoadManifestFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := container.Preload(context.Background(), manifest); err != nil {
+		log.Fatal(err)
+	}
 	ids = []string{"hello0", "hello1", "hello2", "hello3", "hello4", "hello5", "hello6", "hello7", "hello8", "hello9"}
-	// Get env vars
-	injectorURL = os.Getenv("INJECTOR_URL")
-	if injectorURL == "" {
-		injectorURL = "http://injector.default.svc.cluster.local"
+	if manifest != nil {
+		ids = manifest.IDs()
 	}
 
 	http.HandleFunc("/", handler)
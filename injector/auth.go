@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"injectorsdk/grpcapi"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes a token can carry. Writing implies reading.
+const (
+	ScopeRead  = "services:read"
+	ScopeWrite = "services:write"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject string
+	Scopes  []string
+}
+
+func (id Identity) has(scope string) bool {
+	return slices.Contains(id.Scopes, scope) || scope == ScopeRead && slices.Contains(id.Scopes, ScopeWrite)
+}
+
+// identityKey is where requireScope leaves the Identity in the gin.Context
+const identityKey = "identity"
+
+// Authenticator validates bearer tokens: static tokens from a file, JWTs
+// signed with a shared HMAC secret and JWTs signed with a key from a JWKS
+// file, such as projected service account tokens
+type Authenticator struct {
+	tokens   map[string]Identity
+	hmacKey  []byte
+	jwks     *jwksFile
+	issuer   string
+	audience string
+	// defaultScopes are granted to JWTs without scope claims, which
+	// service account tokens never have
+	defaultScopes []string
+}
+
+// auth is nil when no authentication is configured, the API is then open
+var auth *Authenticator
+
+// staticToken is an entry of the AUTH_TOKENS_FILE
+type staticToken struct {
+	Token   string   `yaml:"token"`
+	Subject string   `yaml:"subject"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// newAuthenticatorFromEnv configures authentication from AUTH_TOKENS_FILE,
+// AUTH_JWT_SECRET and AUTH_JWKS_FILE, any of which enables it. JWTs are
+// further checked against AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE if set, and
+// get AUTH_DEFAULT_SCOPES (services:read if unset) when they carry none.
+func newAuthenticatorFromEnv() (*Authenticator, error) {
+	tokensFile := os.Getenv("AUTH_TOKENS_FILE")
+	secret := os.Getenv("AUTH_JWT_SECRET")
+	jwksPath := os.Getenv("AUTH_JWKS_FILE")
+	if tokensFile == "" && secret == "" && jwksPath == "" {
+		return nil, nil
+	}
+
+	a := &Authenticator{
+		hmacKey:       []byte(secret),
+		issuer:        os.Getenv("AUTH_JWT_ISSUER"),
+		audience:      os.Getenv("AUTH_JWT_AUDIENCE"),
+		defaultScopes: []string{ScopeRead},
+	}
+	if scopes := os.Getenv("AUTH_DEFAULT_SCOPES"); scopes != "" {
+		a.defaultScopes = strings.Fields(scopes)
+	}
+
+	if tokensFile != "" {
+		data, err := os.ReadFile(tokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tokens file: %w", err)
+		}
+		var f struct {
+			Tokens []staticToken `yaml:"tokens"`
+		}
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse tokens file %s: %w", tokensFile, err)
+		}
+		a.tokens = make(map[string]Identity, len(f.Tokens))
+		for i, t := range f.Tokens {
+			if t.Token == "" || t.Subject == "" {
+				return nil, fmt.Errorf("tokens file %s: entry %d needs a token and a subject", tokensFile, i)
+			}
+			a.tokens[t.Token] = Identity{Subject: t.Subject, Scopes: t.Scopes}
+		}
+	}
+
+	if jwksPath != "" {
+		jwks, err := newJWKSFile(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = jwks
+	}
+	return a, nil
+}
+
+// Authenticate returns the identity token stands for
+func (a *Authenticator) Authenticate(token string) (Identity, error) {
+	for known, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return id, nil
+		}
+	}
+	if strings.Count(token, ".") != 2 {
+		return Identity{}, errors.New("unknown token")
+	}
+
+	claims, err := verifyJWT(token, a.hmacKey, a.jwks)
+	if err != nil {
+		return Identity{}, err
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return Identity{}, fmt.Errorf("issuer %q not accepted", claims.Issuer)
+	}
+	if a.audience != "" && !slices.Contains(claims.Audience, a.audience) {
+		return Identity{}, fmt.Errorf("audience %v not accepted", []string(claims.Audience))
+	}
+
+	id := Identity{Subject: claims.Subject}
+	if claims.K8s != nil && claims.K8s.ServiceAccount.Name != "" {
+		// Functions are known by their service account, not the long sub
+		id.Subject = claims.K8s.Namespace + "/" + claims.K8s.ServiceAccount.Name
+	}
+	if id.Subject == "" {
+		return Identity{}, errors.New("token has no subject")
+	}
+	switch {
+	case claims.Scope != "":
+		id.Scopes = strings.Fields(claims.Scope)
+	case len(claims.Scopes) > 0:
+		id.Scopes = claims.Scopes
+	default:
+		id.Scopes = a.defaultScopes
+	}
+	return id, nil
+}
+
+// errNoCredentials is returned by identify when a caller presents neither a
+// client certificate nor a bearer token
+var errNoCredentials = errors.New("missing credentials")
+
+// identify returns the identity of a caller from its verified client
+// certificate, if mTLS is on, or else from its Authorization header
+func identify(state *tls.ConnectionState, authorization string) (Identity, error) {
+	if id, ok := certIdentity(state); ok {
+		return id, nil
+	}
+	token, ok := bearerToken(authorization)
+	if !ok || auth == nil {
+		return Identity{}, errNoCredentials
+	}
+	return auth.Authenticate(token)
+}
+
+// requireScope rejects requests whose caller cannot be identified or lacks
+// scope. The API stays open when neither authentication nor mTLS is
+// configured.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth == nil && !mtls {
+			c.Next()
+			return
+		}
+
+		id, err := identify(c.Request.TLS, c.GetHeader("Authorization"))
+		if errors.Is(err, errNoCredentials) {
+			c.Header("WWW-Authenticate", `Bearer realm="injector"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing credentials"})
+			return
+		}
+		if err != nil {
+			logger.Infof("Rejected token for %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.Header("WWW-Authenticate", `Bearer realm="injector", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		if !id.has(scope) {
+			logger.Infof("'%s' lacks scope %s for %s %s", id.Subject, scope, c.Request.Method, c.Request.URL.Path)
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="injector", error="insufficient_scope", scope="%s"`, scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient scope"})
+			return
+		}
+
+		c.Set(identityKey, id)
+		c.Next()
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// grpcScopes are the scopes the gRPC methods need, reads for the rest
+var grpcScopes = map[string]string{
+	grpcapi.Injector_Register_FullMethodName: ScopeWrite,
+}
+
+// authenticateRPC does for the gRPC API what requireScope does for HTTP,
+// with the token in the authorization metadata. The returned context carries
+// the caller's Identity.
+func authenticateRPC(ctx context.Context, method string) (context.Context, error) {
+	if auth == nil && !mtls {
+		return ctx, nil
+	}
+
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	var authorization string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+
+	id, err := identify(state, authorization)
+	if errors.Is(err, errNoCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	if err != nil {
+		logger.Infof("Rejected token for %s: %v", method, err)
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	scope := ScopeRead
+	if s, ok := grpcScopes[method]; ok {
+		scope = s
+	}
+	if !id.has(scope) {
+		logger.Infof("'%s' lacks scope %s for %s", id.Subject, scope, method)
+		return nil, status.Error(codes.PermissionDenied, "insufficient scope")
+	}
+	return context.WithValue(ctx, identityContextKey{}, id), nil
+}
+
+type identityContextKey struct{}
+
+// rpcCallerID is callerID for the gRPC API, with the claimed id in the
+// x-caller-id metadata
+func rpcCallerID(ctx context.Context) string {
+	if id, ok := ctx.Value(identityContextKey{}).(Identity); ok {
+		return id.Subject
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("x-caller-id"); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return "anonymous"
+}
+
+func authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticateRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticateRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, identityStream{ServerStream: ss, ctx: ctx})
+}
+
+// identityStream hands the authenticated context to stream handlers
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s identityStream) Context() context.Context {
+	return s.ctx
+}
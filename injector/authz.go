@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a caller can be authorized for on a service
+const (
+	// ActionResolve hands out the descriptor, with connection details and
+	// credentials, as resolutions do
+	ActionResolve = "resolve"
+	// ActionRead shows the redacted descriptor, in listings, history and
+	// watch streams
+	ActionRead = "read"
+	// ActionWrite registers, changes, deletes or rolls back the service
+	ActionWrite = "write"
+)
+
+// ErrDenied is returned by checkAccess when the caller is not authorized,
+// ErrAuthzUnavailable when no decision could be made
+var (
+	ErrDenied           = errors.New("access denied")
+	ErrAuthzUnavailable = errors.New("authorization unavailable")
+)
+
+// AuthzInput is what an authorization decision is made on. It is also the
+// input document sent to OPA.
+type AuthzInput struct {
+	Caller  string `json:"caller"`
+	Service string `json:"service"`
+	Action  string `json:"action"`
+}
+
+// Authorizer decides whether a caller may perform an action on a service
+type Authorizer interface {
+	Authorize(ctx context.Context, in AuthzInput) (bool, error)
+}
+
+// authz is nil when no authorization is configured, every caller may then do
+// everything its token's scopes allow
+var authz Authorizer
+
+// newAuthorizerFromEnv builds the authorizer for AUTHZ_RULES_FILE or
+// AUTHZ_OPA_URL, e.g. http://opa:8181/v1/data/injector/allow. OPA decisions
+// are cached for AUTHZ_CACHE_TTL.
+func newAuthorizerFromEnv() (Authorizer, error) {
+	rulesFile := os.Getenv("AUTHZ_RULES_FILE")
+	opaURL := os.Getenv("AUTHZ_OPA_URL")
+	switch {
+	case rulesFile != "" && opaURL != "":
+		return nil, errors.New("set either AUTHZ_RULES_FILE or AUTHZ_OPA_URL, not both")
+	case rulesFile != "":
+		return loadRules(rulesFile)
+	case opaURL != "":
+		return &opaAuthorizer{
+			url:      opaURL,
+			client:   &http.Client{Timeout: 2 * time.Second},
+			cacheTTL: durationEnv("AUTHZ_CACHE_TTL", 5*time.Second),
+			cache:    make(map[AuthzInput]opaDecision),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Rule grants, or with Deny refuses, the actions on the services to the
+// callers. Callers and services are path.Match patterns, so "default/*"
+// matches every service account in a namespace. A lone "*" matches any id,
+// service account names with their slash included.
+type Rule struct {
+	Callers  []string `yaml:"callers"`
+	Services []string `yaml:"services"`
+	Actions  []string `yaml:"actions"`
+	Deny     bool     `yaml:"deny"`
+}
+
+// ruleAuthorizer decides from a rule file. Whatever no rule allows is
+// denied, and a matching deny rule wins over any allow.
+type ruleAuthorizer struct {
+	rules []Rule
+}
+
+func loadRules(path string) (*ruleAuthorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	var f struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+
+	for i, rule := range f.Rules {
+		if len(rule.Callers) == 0 || len(rule.Services) == 0 || len(rule.Actions) == 0 {
+			return nil, fmt.Errorf("rules %s: rule %d needs callers, services and actions", path, i)
+		}
+		for _, list := range [][]string{rule.Callers, rule.Services, rule.Actions} {
+			for _, pattern := range list {
+				if _, err := matchAny([]string{pattern}, ""); err != nil {
+					return nil, fmt.Errorf("rules %s: rule %d: invalid pattern %q", path, i, pattern)
+				}
+			}
+		}
+	}
+	logger.Infof("Loaded %d authorization rules from %s", len(f.Rules), path)
+	return &ruleAuthorizer{rules: f.Rules}, nil
+}
+
+func (a *ruleAuthorizer) Authorize(ctx context.Context, in AuthzInput) (bool, error) {
+	allowed := false
+	for _, rule := range a.rules {
+		if !rule.matches(in) {
+			continue
+		}
+		if rule.Deny {
+			return false, nil
+		}
+		allowed = true
+	}
+	return allowed, nil
+}
+
+func (r Rule) matches(in AuthzInput) bool {
+	caller, _ := matchAny(r.Callers, in.Caller)
+	service, _ := matchAny(r.Services, in.Service)
+	action, _ := matchAny(r.Actions, in.Action)
+	return caller && service && action
+}
+
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true, nil
+		}
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// opaAuthorizer asks an OPA decision API, the way ACLService.Authorize in
+// caller-ACL does: POST {"input": ...} and read a boolean result. An
+// undefined result denies.
+type opaAuthorizer struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[AuthzInput]opaDecision
+}
+
+type opaDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// maxDecisions bounds the decision cache, it is emptied when full
+const maxDecisions = 10000
+
+func (a *opaAuthorizer) Authorize(ctx context.Context, in AuthzInput) (bool, error) {
+	a.mu.Lock()
+	d, ok := a.cache[in]
+	a.mu.Unlock()
+	if ok && time.Now().Before(d.expires) {
+		return d.allowed, nil
+	}
+
+	allowed, err := a.query(ctx, in)
+	if err != nil {
+		return false, err
+	}
+
+	if a.cacheTTL > 0 {
+		a.mu.Lock()
+		if len(a.cache) >= maxDecisions {
+			a.cache = make(map[AuthzInput]opaDecision)
+		}
+		a.cache[in] = opaDecision{allowed: allowed, expires: time.Now().Add(a.cacheTTL)}
+		a.mu.Unlock()
+	}
+	return allowed, nil
+}
+
+func (a *opaAuthorizer) query(ctx context.Context, in AuthzInput) (bool, error) {
+	body, err := json.Marshal(struct {
+		Input AuthzInput `json:"input"`
+	}{in})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to query OPA: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, fmt.Errorf("failed to read OPA response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("OPA returned %s: %s", resp.Status, data)
+	}
+
+	var decision struct {
+		Result *bool `json:"result"`
+	}
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return false, fmt.Errorf("invalid OPA response: %w", err)
+	}
+	return decision.Result != nil && *decision.Result, nil
+}
+
+// checkAccess returns nil if caller may perform action on id, ErrDenied if
+// not. Denials and failed decisions, which deny as well, are audit logged.
+func checkAccess(ctx context.Context, caller, id, action string) error {
+	if authz == nil {
+		return nil
+	}
+
+	allowed, err := authz.Authorize(ctx, AuthzInput{Caller: caller, Service: id, Action: action})
+	if err != nil {
+		logger.Warnf("AUDIT denied caller='%s' service='%s' action=%s: decision failed: %v", caller, id, action, err)
+		return fmt.Errorf("%w: %v", ErrAuthzUnavailable, err)
+	}
+	if !allowed {
+		logger.Warnf("AUDIT denied caller='%s' service='%s' action=%s", caller, id, action)
+		return ErrDenied
+	}
+	return nil
+}
+
+// checkAccessAll splits ids into those caller may perform action on and the
+// checkAccess errors of the others
+func checkAccessAll(ctx context.Context, caller string, ids []string, action string) ([]string, map[string]error) {
+	if authz == nil {
+		return ids, nil
+	}
+
+	var allowed []string
+	denied := make(map[string]error)
+	for _, id := range ids {
+		if err := checkAccess(ctx, caller, id, action); err != nil {
+			denied[id] = err
+			continue
+		}
+		allowed = append(allowed, id)
+	}
+	return allowed, denied
+}
+
+// checkAccessRPC is checkAccess for the caller of a gRPC call, with the
+// matching status code
+func checkAccessRPC(ctx context.Context, id, action string) error {
+	if err := checkAccess(ctx, rpcCallerID(ctx), id, action); err != nil {
+		return rpcError(err)
+	}
+	return nil
+}
+
+// authorize runs checkAccess for the caller of c, answering 403, or 503 if
+// no decision could be made, when access is not granted
+func authorize(c *gin.Context, id, action string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := checkAccess(ctx, callerID(c), id, action); err != nil {
+		c.JSON(httpStatus(err), gin.H{"error": errorMessage(err)})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"injectorsdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testRules = `
+rules:
+  - callers: ["default/*"]
+    services: ["hello*"]
+    actions: ["resolve", "read"]
+  - callers: ["default/admin"]
+    services: ["*"]
+    actions: ["*"]
+  - callers: ["*"]
+    services: ["secret"]
+    actions: ["*"]
+    deny: true
+`
+
+func writeRules(t *testing.T) *ruleAuthorizer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(testRules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestRuleAuthorizer(t *testing.T) {
+	a := writeRules(t)
+
+	tests := []struct {
+		caller, service, action string
+		allowed                 bool
+	}{
+		{"default/fn", "hello", ActionResolve, true},
+		{"default/fn", "hello-v2", ActionRead, true},
+		{"default/fn", "hello", ActionWrite, false},
+		{"default/fn", "minio", ActionResolve, false},
+		{"other/fn", "hello", ActionResolve, false},
+		{"default/admin", "minio", ActionWrite, true},
+		{"default/admin", "hello", ActionResolve, true},
+		// Deny wins over any allow
+		{"default/admin", "secret", ActionRead, false},
+		{"default/fn", "secret", ActionResolve, false},
+	}
+	for _, tt := range tests {
+		in := AuthzInput{Caller: tt.caller, Service: tt.service, Action: tt.action}
+		allowed, err := a.Authorize(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%+v: allowed %v, want %v", in, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestLoadRulesInvalid(t *testing.T) {
+	for _, rules := range []string{
+		`rules: [{callers: ["*"], services: ["*"]}]`,
+		`rules: [{callers: ["[oops"], services: ["*"], actions: ["*"]}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "rules.yaml")
+		os.WriteFile(path, []byte(rules), 0o600)
+		if _, err := loadRules(path); err == nil {
+			t.Errorf("loaded %s", rules)
+		}
+	}
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	var queries atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		var body struct {
+			Input AuthzInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid OPA request: %v", err)
+		}
+		in := body.Input
+		switch in.Service {
+		case "undefined":
+			w.Write([]byte(`{}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			allowed := in.Caller == "default/fn" && in.Action != ActionWrite
+			json.NewEncoder(w).Encode(map[string]bool{"result": allowed})
+		}
+	}))
+	defer srv.Close()
+
+	a := &opaAuthorizer{url: srv.URL, client: srv.Client(), cacheTTL: time.Minute, cache: make(map[AuthzInput]opaDecision)}
+
+	tests := []struct {
+		caller, service, action string
+		allowed, fails          bool
+	}{
+		{"default/fn", "hello", ActionResolve, true, false},
+		{"default/fn", "hello", ActionRead, true, false},
+		{"default/fn", "hello", ActionWrite, false, false},
+		{"other/fn", "hello", ActionResolve, false, false},
+		{"default/fn", "undefined", ActionResolve, false, false},
+		{"default/fn", "broken", ActionResolve, false, true},
+	}
+	for _, tt := range tests {
+		in := AuthzInput{Caller: tt.caller, Service: tt.service, Action: tt.action}
+		allowed, err := a.Authorize(context.Background(), in)
+		if (err != nil) != tt.fails {
+			t.Errorf("%+v: error %v", in, err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%+v: allowed %v, want %v", in, allowed, tt.allowed)
+		}
+	}
+
+	// Decisions are cached, failures are not
+	before := queries.Load()
+	a.Authorize(context.Background(), AuthzInput{Caller: "default/fn", Service: "hello", Action: ActionResolve})
+	if queries.Load() != before {
+		t.Error("cached decision queried again")
+	}
+	a.Authorize(context.Background(), AuthzInput{Caller: "default/fn", Service: "broken", Action: ActionResolve})
+	if queries.Load() != before+1 {
+		t.Error("failed decision cached")
+	}
+}
+
+func TestBatchStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	cache = injectorsdk.NewCache[Service](time.Minute, time.Second, 100)
+	for _, id := range []string{"hello", "secret"} {
+		if _, err := store.Put(context.Background(), Service{ID: id, ServiceAddress: "http://" + id}, PutCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authz = writeRules(t)
+	defer func() { authz = nil }()
+
+	r := gin.New()
+	r.GET("/services", listServicesHandler)
+	req := httptest.NewRequest(http.MethodGet, "/services?ids=hello,secret,hello-missing", nil)
+	req.Header.Set("X-Caller-ID", "default/fn")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var result BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Services["hello"]; !ok {
+		t.Fatalf("hello not served: %s", w.Body)
+	}
+	want := map[string]int{"secret": http.StatusForbidden, "hello-missing": http.StatusNotFound}
+	for id, status := range want {
+		if result.Statuses[id] != status {
+			t.Errorf("%s has status %d, want %d", id, result.Statuses[id], status)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const caUsage = `Usage:
+  injector ca init  [-dir certs] [-days 365]
+      create a local CA in dir/ca.crt and dir/ca.key
+  injector ca issue [-dir certs] -name NAME [-dns a,b] [-ip a,b] [-uri a,b] [-days 30] [-force]
+      issue dir/NAME.crt and dir/NAME.key, signed by the local CA; -force
+      replaces an existing NAME.crt and NAME.key
+
+Issued certificates are valid for both servers and clients. Give the
+injector -dns names it is reached by, callers a -uri such as
+spiffe://cluster.local/ns/default/sa/caller-minio to identify them.
+`
+
+// caCommand runs "injector ca ...", a CA for test certificates so TLS and
+// mTLS can be tried without any other tooling. Not meant for production.
+func caCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, caUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = caInit(args[1:])
+	case "issue":
+		err = caIssue(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, caUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "injector ca %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func caInit(args []string) error {
+	fs := flag.NewFlagSet("ca init", flag.ContinueOnError)
+	dir := fs.String("dir", "certs", "directory for the CA files")
+	days := fs.Int("days", 365, "validity in days")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	certFile, keyFile := filepath.Join(*dir, "ca.crt"), filepath.Join(*dir, "ca.key")
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("%s already exists", keyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certTemplate("Injector local CA", *days)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s and %s\n", certFile, keyFile)
+	return nil
+}
+
+func caIssue(args []string) error {
+	fs := flag.NewFlagSet("ca issue", flag.ContinueOnError)
+	dir := fs.String("dir", "certs", "directory with the CA files")
+	name := fs.String("name", "", "certificate name, used for the file names and the subject")
+	dns := fs.String("dns", "", "comma separated DNS names")
+	ips := fs.String("ip", "", "comma separated IP addresses")
+	uris := fs.String("uri", "", "comma separated URIs, e.g. SPIFFE ids")
+	days := fs.Int("days", 30, "validity in days")
+	force := fs.Bool("force", false, "replace an existing certificate of the same name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || strings.ContainsAny(*name, `/\`) {
+		return errors.New("-name must be a plain file name")
+	}
+	if strings.EqualFold(*name, "ca") {
+		// Issuing to ca.crt and ca.key would replace the CA itself
+		return errors.New("-name ca is reserved for the CA files")
+	}
+	certFile, keyFile := filepath.Join(*dir, *name+".crt"), filepath.Join(*dir, *name+".key")
+	if !*force {
+		for _, file := range []string{certFile, keyFile} {
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s already exists, give -force to replace it", file)
+			}
+		}
+	}
+
+	caCert, caKey, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+
+	template, err := certTemplate(*name, *days)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.DNSNames = splitList(*dns)
+	for _, s := range splitList(*ips) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", s)
+		}
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+	for _, s := range splitList(*uris) {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid URI %q", s)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	if len(template.DNSNames)+len(template.IPAddresses)+len(template.URIs) == 0 {
+		return errors.New("give at least one of -dns, -ip and -uri")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return err
+	}
+	fmt.Printf("Issued %s and %s\n", certFile, keyFile)
+	return nil
+}
+
+func certTemplate(commonName string, days int) (*x509.Certificate, error) {
+	if days < 1 {
+		return nil, errors.New("-days must be positive")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		// Tolerate clocks slightly behind this one
+		NotBefore: now.Add(-5 * time.Minute),
+		NotAfter:  now.AddDate(0, 0, days),
+	}, nil
+}
+
+func loadCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA, run injector ca init first: %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "ca.key"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, errors.New("no certificate in ca.crt")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, errors.New("no key in ca.key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("ca.key is not an ECDSA key")
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readCert parses the PEM certificate in file
+func readCert(t *testing.T, file string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM in %s", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCAInitIssue(t *testing.T) {
+	dir := t.TempDir()
+	if err := caInit([]string{"-dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := caInit([]string{"-dir", dir}); err == nil {
+		t.Fatal("second init replaced the CA")
+	}
+	caPEM, _ := os.ReadFile(filepath.Join(dir, "ca.crt"))
+
+	uri := "spiffe://cluster.local/ns/default/sa/caller-minio"
+	if err := caIssue([]string{"-dir", dir, "-name", "caller", "-uri", uri}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, "caller.crt"), filepath.Join(dir, "caller.key")); err != nil {
+		t.Fatalf("issued key pair does not load: %v", err)
+	}
+	cert := readCert(t, filepath.Join(dir, "caller.crt"))
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Fatalf("issued certificate not valid for %v: %v", usage, err)
+		}
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != uri {
+		t.Fatalf("issued URIs %v, want %s", cert.URIs, uri)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"reserved name", []string{"-name", "ca", "-dns", "ca"}},
+		{"reserved name in capitals", []string{"-name", "CA", "-dns", "ca"}},
+		{"path as name", []string{"-name", "../caller", "-dns", "caller"}},
+		{"existing name", []string{"-name", "caller", "-dns", "caller"}},
+		{"no SAN", []string{"-name", "bare"}},
+		{"invalid IP", []string{"-name", "bad-ip", "-ip", "300.1.1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := caIssue(append([]string{"-dir", dir}, tt.args...)); err == nil {
+				t.Fatal("issued")
+			}
+		})
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ca.crt")); !bytes.Equal(data, caPEM) {
+		t.Fatal("CA certificate replaced")
+	}
+	if !readCert(t, filepath.Join(dir, "caller.crt")).Equal(cert) {
+		t.Fatal("existing certificate replaced without -force")
+	}
+
+	if err := caIssue([]string{"-dir", dir, "-name", "caller", "-dns", "caller", "-force"}); err != nil {
+		t.Fatal(err)
+	}
+	if readCert(t, filepath.Join(dir, "caller.crt")).Equal(cert) {
+		t.Fatal("-force kept the existing certificate")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// Credentials are temporary keys scoped to one caller and one bucket
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expires      time.Time
+}
+
+// CredentialRequest describes the credentials a caller needs for a service
+type CredentialRequest struct {
+	Caller    string
+	ServiceID string
+	Bucket    string
+	TTL       time.Duration
+}
+
+// CredentialBroker mints credentials, e.g. through an STS AssumeRole call
+// with a session policy limited to the bucket
+type CredentialBroker interface {
+	Mint(ctx context.Context, req CredentialRequest) (Credentials, error)
+}
+
+// newBrokerFromEnv builds the broker selected by CREDENTIAL_BROKER. Without
+// one descriptors are served as stored.
+func newBrokerFromEnv() (CredentialBroker, error) {
+	switch name := os.Getenv("CREDENTIAL_BROKER"); name {
+	case "":
+		return nil, nil
+	case "minio-sts":
+		return newMinioSTSBrokerFromEnv()
+	case "fake":
+		return &fakeBroker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CREDENTIAL_BROKER %q", name)
+	}
+}
+
+// Bounds of CREDENTIAL_TTL, the DurationSeconds range AssumeRole accepts
+const (
+	minCredentialTTL = 15 * time.Minute
+	maxCredentialTTL = 12 * time.Hour
+)
+
+// validateCredentialTTL rejects lifetimes STS would refuse, so a bad
+// CREDENTIAL_TTL fails at startup instead of every minted resolution
+func validateCredentialTTL(ttl time.Duration) error {
+	if ttl < minCredentialTTL || ttl > maxCredentialTTL {
+		return fmt.Errorf("CREDENTIAL_TTL %v is outside %v to %v", ttl, minCredentialTTL, maxCredentialTTL)
+	}
+	return nil
+}
+
+// fakeBroker mints random keys that no server accepts, with the request
+// they were minted for in the session token. It lets the credential path
+// be exercised, and its latency measured, without a MinIO STS endpoint.
+type fakeBroker struct {
+	mints atomic.Int32
+}
+
+func (b *fakeBroker) Mint(ctx context.Context, req CredentialRequest) (Credentials, error) {
+	b.mints.Add(1)
+	return Credentials{
+		AccessKey:    "fake-" + randomHex(8),
+		SecretKey:    randomHex(20),
+		SessionToken: fmt.Sprintf("fake:%s:%s:%s", req.Caller, req.ServiceID, req.Bucket),
+		Expires:      time.Now().Add(req.TTL),
+	}, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var (
+	broker        CredentialBroker
+	credentialTTL time.Duration
+)
+
+// maxMinted caps the credentials kept for reuse, one per caller, service
+// and bucket
+const maxMinted = 10000
+
+// minted reuses credentials for a caller, service and bucket until half of
+// their lifetime is over, so resolutions do not each hit the broker
+var minted struct {
+	mu    sync.Mutex
+	creds map[CredentialRequest]Credentials
+	mints singleflight.Group
+}
+
+// withCredentials replaces the keys of a MinIO descriptor with credentials
+// minted for caller and the descriptor's bucket, if a broker is configured
+func withCredentials(service Service, caller string) (Service, error) {
+	if broker == nil || service.Kind != "minio" {
+		return service, nil
+	}
+
+	bucket, _ := service.Attributes["Bucket"].(string)
+	req := CredentialRequest{Caller: caller, ServiceID: service.ID, Bucket: bucket, TTL: credentialTTL}
+
+	creds, err := mintedCredentials(req)
+	if err != nil {
+		return Service{}, err
+	}
+
+	service = service.clone()
+	if service.Attributes == nil {
+		service.Attributes = make(map[string]interface{})
+	}
+	service.Attributes["Admin"] = creds.AccessKey
+	service.Attributes["Password"] = creds.SecretKey
+	service.Attributes["SessionToken"] = creds.SessionToken
+	expires := creds.Expires.UTC()
+	service.Expires = &expires
+	return service, nil
+}
+
+// mintedCredentials returns the credentials kept for req, or mints new ones
+// once they are due. Resolutions needing the same credentials share one
+// Mint call, which runs without holding the lock.
+func mintedCredentials(req CredentialRequest) (Credentials, error) {
+	minted.mu.Lock()
+	creds, ok := minted.creds[req]
+	minted.mu.Unlock()
+	if ok && !renewDue(creds, req.TTL) {
+		return creds, nil
+	}
+
+	key := req.Caller + "\x00" + req.ServiceID + "\x00" + req.Bucket
+	v, err, _ := minted.mints.Do(key, func() (interface{}, error) {
+		// Not tied to any one request, the result is handed to every waiter
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		creds, err := broker.Mint(ctx, req)
+		if err != nil {
+			return Credentials{}, err
+		}
+		keepMinted(req, creds)
+		logger.Infof("Minted credentials for '%s' on '%s' until %s", req.Caller, req.ServiceID, creds.Expires.Format(time.RFC3339))
+		return creds, nil
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+	return v.(Credentials), nil
+}
+
+// keepMinted stores creds for reuse. Once the cache is full, credentials due
+// for renewal are dropped, then arbitrary ones until a tenth is free again.
+func keepMinted(req CredentialRequest, creds Credentials) {
+	minted.mu.Lock()
+	defer minted.mu.Unlock()
+
+	if minted.creds == nil {
+		minted.creds = make(map[CredentialRequest]Credentials)
+	}
+	if len(minted.creds) >= maxMinted {
+		for r, c := range minted.creds {
+			if renewDue(c, r.TTL) {
+				delete(minted.creds, r)
+			}
+		}
+		for r := range minted.creds {
+			if len(minted.creds) < maxMinted*9/10 {
+				break
+			}
+			delete(minted.creds, r)
+		}
+	}
+	minted.creds[req] = creds
+}
+
+// renewDue reports whether less than half of the lifetime of creds is left
+func renewDue(creds Credentials, ttl time.Duration) bool {
+	return time.Until(creds.Expires) < ttl/2
+}
+
+// callerID names the caller credentials are minted for: the authenticated
+// subject, or the X-Caller-ID the caller claims when authentication is off
+func callerID(c *gin.Context) string {
+	if id, ok := c.Get(identityKey); ok {
+		return id.(Identity).Subject
+	}
+	if id := c.GetHeader("X-Caller-ID"); id != "" {
+		return id
+	}
+	return "anonymous"
+}
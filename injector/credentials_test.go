@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"injectorsdk"
+	"injectorsdk/grpcapi"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// setupCredentials serves a MinIO descriptor with credentials from a fresh
+// fakeBroker
+func setupCredentials(t *testing.T) *fakeBroker {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store = newMemoryStore()
+	cache = injectorsdk.NewCache[Service](time.Minute, time.Second, 100)
+	_, err := store.Put(context.Background(), Service{
+		ID:             "minio",
+		ServiceAddress: "http://minio:9000",
+		Kind:           "minio",
+		Attributes:     map[string]interface{}{"Bucket": "data", "Admin": "root", "Password": "rootpw"},
+	}, PutCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeBroker{}
+	broker, credentialTTL = fake, time.Minute
+	minted.mu.Lock()
+	minted.creds = nil
+	minted.mu.Unlock()
+	t.Cleanup(func() { broker = nil })
+	return fake
+}
+
+func checkMinted(t *testing.T, transport, caller string, attrs map[string]interface{}) {
+	t.Helper()
+	if admin, _ := attrs["Admin"].(string); !strings.HasPrefix(admin, "fake-") {
+		t.Fatalf("%s served Admin %q, want minted credentials", transport, admin)
+	}
+	if want := "fake:" + caller + ":minio:data"; attrs["SessionToken"] != want {
+		t.Fatalf("%s served SessionToken %v, want %q", transport, attrs["SessionToken"], want)
+	}
+}
+
+func TestCredentialsOnBothTransports(t *testing.T) {
+	setupCredentials(t)
+
+	r := gin.New()
+	r.GET("/services", listServicesHandler)
+	r.GET("/services/:id", getServiceHandler)
+
+	get := func(path string, v interface{}) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Caller-ID", "alice")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s returned %d: %s", path, w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Decoded as plain JSON, a Service drops the Expires served to callers
+	var service map[string]interface{}
+	get("/services/minio", &service)
+	checkMinted(t, "HTTP", "alice", service)
+	if service["Expires"] == nil {
+		t.Fatal("HTTP served no expiry")
+	}
+
+	var batch struct {
+		Services map[string]map[string]interface{} `json:"services"`
+	}
+	get("/services?ids=minio", &batch)
+	checkMinted(t, "HTTP batch", "alice", batch.Services["minio"])
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpcapi.NewInjectorClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-caller-id", "bob")
+
+	pb, err := client.Resolve(ctx, &grpcapi.ResolveRequest{Id: "minio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMinted(t, "gRPC", "bob", pb.Attributes.AsMap())
+	if pb.Expires == nil {
+		t.Fatal("gRPC served no expiry")
+	}
+
+	resp, err := client.BatchResolve(ctx, &grpcapi.BatchResolveRequest{Ids: []string{"minio"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMinted(t, "gRPC batch", "bob", resp.Services["minio"].Attributes.AsMap())
+}
+
+func TestMintedCredentialsShared(t *testing.T) {
+	fake := setupCredentials(t)
+	req := CredentialRequest{Caller: "alice", ServiceID: "minio", Bucket: "data", TTL: time.Minute}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := mintedCredentials(req); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := fake.mints.Load(); got != 1 {
+		t.Fatalf("minted %d times, want 1", got)
+	}
+
+	// Past half of their lifetime credentials are minted anew
+	minted.mu.Lock()
+	creds := minted.creds[req]
+	creds.Expires = time.Now().Add(10 * time.Second)
+	minted.creds[req] = creds
+	minted.mu.Unlock()
+	if _, err := mintedCredentials(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.mints.Load(); got != 2 {
+		t.Fatalf("minted %d times, want 2", got)
+	}
+}
+
+func TestMintedCredentialsBounded(t *testing.T) {
+	setupCredentials(t)
+	now := time.Now()
+
+	stale := CredentialRequest{Caller: "stale", TTL: time.Minute}
+	keepMinted(stale, Credentials{Expires: now.Add(-time.Second)})
+	for i := 0; i < maxMinted+10; i++ {
+		keepMinted(CredentialRequest{Caller: fmt.Sprint(i), TTL: time.Minute}, Credentials{Expires: now.Add(time.Minute)})
+	}
+
+	minted.mu.Lock()
+	defer minted.mu.Unlock()
+	if _, ok := minted.creds[stale]; ok {
+		t.Fatal("expired credentials kept")
+	}
+	if len(minted.creds) > maxMinted {
+		t.Fatalf("kept %d credentials, want at most %d", len(minted.creds), maxMinted)
+	}
+}
+
+func TestMinioSTSBroker(t *testing.T) {
+	expires := time.Now().Add(15 * time.Minute).UTC().Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=broker/") {
+			t.Errorf("request not signed by the broker: %q", r.Header.Get("Authorization"))
+		}
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRole" || r.Form.Get("DurationSeconds") != "900" {
+			t.Errorf("unexpected request %v", r.Form)
+		}
+		if strings.Contains(r.Form.Get("Policy"), `"arn:aws:s3:::private/*"`) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+			return
+		}
+		if !strings.Contains(r.Form.Get("Policy"), `"arn:aws:s3:::data/*"`) {
+			t.Errorf("session policy %s not limited to the bucket", r.Form.Get("Policy"))
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+			`<AccessKeyId>temp-access</AccessKeyId><SecretAccessKey>temp-secret</SecretAccessKey>`+
+			`<SessionToken>temp-token</SessionToken><Expiration>%s</Expiration>`+
+			`</Credentials></AssumeRoleResult></AssumeRoleResponse>`, expires.Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	t.Setenv("MINIO_STS_ENDPOINT", srv.URL)
+	t.Setenv("MINIO_STS_ACCESS_KEY", "broker")
+	t.Setenv("MINIO_STS_SECRET_KEY", "broker-secret")
+	b, err := newMinioSTSBrokerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := CredentialRequest{Caller: "alice", ServiceID: "minio", Bucket: "data", TTL: 15 * time.Minute}
+	creds, err := b.Mint(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Credentials{AccessKey: "temp-access", SecretKey: "temp-secret", SessionToken: "temp-token", Expires: expires}
+	if creds != want {
+		t.Fatalf("got %+v, want %+v", creds, want)
+	}
+
+	req.Bucket = "private"
+	if _, err := b.Mint(context.Background(), req); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("got error %v, want AccessDenied", err)
+	}
+
+	req.Bucket = ""
+	if _, err := b.Mint(context.Background(), req); err == nil {
+		t.Fatal("minted credentials without a bucket")
+	}
+}
+
+func TestBrokerFromEnv(t *testing.T) {
+	t.Setenv("CREDENTIAL_BROKER", "fake")
+	b, err := newBrokerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := b.Mint(context.Background(), CredentialRequest{Caller: "alice", ServiceID: "minio", Bucket: "data", TTL: time.Hour})
+	if err != nil || !strings.HasPrefix(creds.AccessKey, "fake-") || time.Until(creds.Expires) < 59*time.Minute {
+		t.Fatalf("fake broker minted %+v, %v", creds, err)
+	}
+
+	t.Setenv("CREDENTIAL_BROKER", "vault")
+	if _, err := newBrokerFromEnv(); err == nil {
+		t.Fatal("unknown broker accepted")
+	}
+}
+
+func TestValidateCredentialTTL(t *testing.T) {
+	tests := []struct {
+		ttl time.Duration
+		ok  bool
+	}{
+		{time.Minute, false},
+		{15*time.Minute - time.Second, false},
+		{15 * time.Minute, true},
+		{time.Hour, true},
+		{12 * time.Hour, true},
+		{12*time.Hour + time.Second, false},
+		{0, false},
+	}
+	for _, tt := range tests {
+		if err := validateCredentialTTL(tt.ttl); (err == nil) != tt.ok {
+			t.Errorf("%v: got %v, want valid %v", tt.ttl, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Load balancing strategies for services with several endpoints. The last
+// two need call observations that only clients have, so the injector
+// serves those round robin and leaves the real choice to the SDK.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyRandom           = "random"
+	StrategyLeastOutstanding = "least-outstanding"
+	StrategyLatency          = "latency"
+)
+
+// roundRobin holds the next endpoint index per service id
+var roundRobin sync.Map // map[string]*atomic.Uint64
+
+// decodeEndpoints converts the generic JSON value of an Endpoints key
+func decodeEndpoints(v interface{}) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Endpoints must be a list of addresses")
+	}
+	endpoints := make([]string, len(items))
+	for i, item := range items {
+		if endpoints[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("Endpoints must be a list of addresses")
+		}
+	}
+	return endpoints, nil
+}
+
+func validateEndpoints(endpoints []string, strategy string) error {
+	for _, endpoint := range endpoints {
+		if strings.TrimSpace(endpoint) == "" {
+			return fmt.Errorf("Endpoints must not contain empty addresses")
+		}
+	}
+	switch strategy {
+	case "", StrategyRoundRobin, StrategyRandom, StrategyLeastOutstanding, StrategyLatency:
+		return nil
+	default:
+		return fmt.Errorf("unknown Strategy %q", strategy)
+	}
+}
+
+// pickEndpoint serves one of the service's endpoints as its ServiceAddress.
+// The endpoint list stays in the descriptor for clients that balance
+// themselves.
+func pickEndpoint(service Service) Service {
+	if len(service.Endpoints) == 0 {
+		return service
+	}
+
+	var i int
+	if service.Strategy == StrategyRandom {
+		i = rand.Intn(len(service.Endpoints))
+	} else {
+		counter, _ := roundRobin.LoadOrStore(service.ID, new(atomic.Uint64))
+		i = int((counter.(*atomic.Uint64).Add(1) - 1) % uint64(len(service.Endpoints)))
+	}
+
+	service.ServiceAddress = service.Endpoints[i]
+	return service
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// WatchEvent is the data of a server-sent event on the watch endpoints
+type WatchEvent struct {
+	Type     EventType `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	Revision int64     `json:"revision,omitempty"`
+	Service  *Service  `json:"service,omitempty"`
+}
+
+// heartbeatInterval keeps idle streams from being cut by proxies
+const heartbeatInterval = 15 * time.Second
+
+// watchServiceHandler streams changes to a single service. The current state
+// is sent first, unless the client already has it according to Last-Event-ID.
+func watchServiceHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionRead) {
+		return
+	}
+	logger.Infof("Watching service with ID: %s", id)
+
+	// Subscribe before reading the current state so nothing falls in between
+	events := changes.subscribe(c.Request.Context())
+	startStream(c)
+
+	ev := currentState(id)
+	if ev.Type != EventPut || c.GetHeader("Last-Event-ID") != strconv.FormatInt(ev.Revision, 10) {
+		writeEvent(c, ev)
+	}
+
+	stream(c, events, func(ev Event) bool { return ev.Type == EventReset || ev.ID == id })
+}
+
+// currentState is the first event a new watcher of id receives
+func currentState(id string) Event {
+	service, err := resolve(id)
+	switch {
+	case err == nil:
+		return Event{Type: EventPut, ID: id, Revision: service.Revision, Service: &service}
+	case errors.Is(err, ErrNotFound):
+		return Event{Type: EventDelete, ID: id}
+	default:
+		logger.Infof("Error finding service with id '%s': %v", id, err)
+		return Event{Type: EventReset}
+	}
+}
+
+// watchServicesHandler streams changes to every service in the registry
+func watchServicesHandler(c *gin.Context) {
+	logger.Infof("Watching all services")
+
+	events := changes.subscribe(c.Request.Context())
+	startStream(c)
+	caller := callerID(c)
+	stream(c, events, func(ev Event) bool { return canWatch(c.Request.Context(), caller, ev) })
+}
+
+// canWatch reports whether caller may see ev on a stream of all services
+func canWatch(ctx context.Context, caller string, ev Event) bool {
+	return ev.Type == EventReset || checkAccess(ctx, caller, ev.ID, ActionRead) == nil
+}
+
+func startStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+}
+
+func stream(c *gin.Context, events <-chan Event, match func(Event) bool) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			if match(ev) {
+				writeEvent(c, ev)
+			}
+			return true
+		case <-heartbeat.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func writeEvent(c *gin.Context, ev Event) {
+	e := sse.Event{
+		Event: string(ev.Type),
+		Data:  newWatchEvent(ev),
+	}
+	if ev.Revision != 0 {
+		e.Id = strconv.FormatInt(ev.Revision, 10)
+	}
+	c.Render(-1, e)
+	c.Writer.Flush()
+}
+
+// newWatchEvent is the event sent to watchers, over SSE and gRPC alike. The
+// descriptor is redacted like in listings, watching only needs read access.
+func newWatchEvent(ev Event) WatchEvent {
+	w := WatchEvent{Type: ev.Type, ID: ev.ID, Revision: ev.Revision}
+	if ev.Service != nil {
+		service := redact(*ev.Service)
+		w.Service = &service
+	}
+	return w
+}
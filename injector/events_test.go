@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchEventRedacted(t *testing.T) {
+	service := Service{ID: "minio", Attributes: map[string]interface{}{
+		"Password": "plaintext",
+		"Admin":    "secret:k8s:minio-credentials/user",
+		"Bucket":   "data",
+	}}
+	w := newWatchEvent(Event{Type: EventPut, ID: "minio", Service: &service})
+
+	if got := w.Service.Attributes["Password"]; got != redacted {
+		t.Fatalf("Password sent as %q", got)
+	}
+	if got := w.Service.Attributes["Admin"]; got != "secret:k8s:minio-credentials/user" {
+		t.Fatalf("secret reference sent as %q", got)
+	}
+	if got := w.Service.Attributes["Bucket"]; got != "data" {
+		t.Fatalf("Bucket sent as %q", got)
+	}
+	if service.Attributes["Password"] != "plaintext" {
+		t.Fatal("redacting changed the event's descriptor")
+	}
+}
+
+func TestCanWatchNeedsRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := `
+rules:
+  - callers: ["default/dashboard"]
+    services: ["*"]
+    actions: ["read"]
+  - callers: ["default/fn"]
+    services: ["hello"]
+    actions: ["resolve"]
+`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz = a
+	defer func() { authz = nil }()
+
+	ctx := context.Background()
+	put := Event{Type: EventPut, ID: "hello"}
+	if !canWatch(ctx, "default/dashboard", put) {
+		t.Fatal("caller with read access cannot watch")
+	}
+	// Watchers get the redacted descriptor, so resolve access is not enough
+	if canWatch(ctx, "default/fn", put) {
+		t.Fatal("caller without read access can watch")
+	}
+	if !canWatch(ctx, "default/fn", Event{Type: EventReset}) {
+		t.Fatal("reset withheld")
+	}
+}
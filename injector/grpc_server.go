@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"injectorsdk/grpcapi"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// injectorServer serves the gRPC API from the same store and cache as the
+// HTTP handlers. See grpcapi/injector.proto for the wire contract.
+type injectorServer struct {
+	grpcapi.UnimplementedInjectorServer
+}
+
+func newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(opts...)
+	grpcapi.RegisterInjectorServer(srv, injectorServer{})
+	return srv
+}
+
+func (injectorServer) Resolve(ctx context.Context, req *grpcapi.ResolveRequest) (*grpcapi.Service, error) {
+	logger.Infof("Fetching service with ID: %s", req.Id)
+
+	start := time.Now()
+
+	service, err := serveService(ctx, rpcCallerID(ctx), req.Id, req.RoutingKey)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	end := time.Now()
+	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
+
+	return serviceProto(service)
+}
+
+func (injectorServer) BatchResolve(ctx context.Context, req *grpcapi.BatchResolveRequest) (*grpcapi.BatchResolveResponse, error) {
+	ids := uniqueIDs(req.Ids)
+	if len(ids) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no ids given")
+	}
+	if len(ids) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids per request", maxBatchSize)
+	}
+	logger.Infof("Fetching %d services in batch", len(ids))
+
+	start := time.Now()
+	result := serveBatch(ctx, rpcCallerID(ctx), ids, req.RoutingKey)
+	end := time.Now()
+	logger.Infof("Services retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
+
+	resp := &grpcapi.BatchResolveResponse{Services: make(map[string]*grpcapi.Service, len(result.Services))}
+	for id, service := range result.Services {
+		pb, err := serviceProto(service)
+		if err != nil {
+			return nil, err
+		}
+		resp.Services[id] = pb
+	}
+	for id, err := range result.failures {
+		if resp.Errors == nil {
+			resp.Errors = make(map[string]*grpcapi.ResolveError)
+		}
+		resp.Errors[id] = &grpcapi.ResolveError{Code: int32(status.Code(rpcError(err))), Message: errorMessage(err)}
+	}
+	return resp, nil
+}
+
+// rpcError is the status error of a failed resolution
+func rpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, errorMessage(err))
+	case errors.Is(err, ErrDenied):
+		return status.Error(codes.PermissionDenied, errorMessage(err))
+	case errors.Is(err, ErrAuthzUnavailable), errors.Is(err, ErrCredentials):
+		return status.Error(codes.Unavailable, errorMessage(err))
+	default:
+		return status.Error(codes.Internal, errorMessage(err))
+	}
+}
+
+func (injectorServer) Register(ctx context.Context, req *grpcapi.RegisterRequest) (*grpcapi.Service, error) {
+	service, err := serviceFromProto(req.Service)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid service: "+err.Error())
+	}
+	if err := validateService(service); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkAccessRPC(ctx, service.ID, ActionWrite); err != nil {
+		return nil, err
+	}
+
+	mode := PutCreate
+	if req.Replace {
+		mode = PutUpsert
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	service, err = store.Put(ctx, service, mode)
+	if errors.Is(err, ErrExists) {
+		return nil, status.Error(codes.AlreadyExists, "service already exists")
+	}
+	if errors.Is(err, ErrConflict) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		logger.Infof("Error registering service '%s': %v", service.ID, err)
+		return nil, status.Error(codes.Internal, "failed to register service")
+	}
+
+	invalidate(service.ID)
+	logger.Infof("Service '%s' registered", service.ID)
+	return serviceProto(service)
+}
+
+func (injectorServer) Watch(req *grpcapi.WatchRequest, stream grpcapi.Injector_WatchServer) error {
+	ctx := stream.Context()
+	if req.Id == "" {
+		logger.Infof("Watching all services")
+	} else {
+		if err := checkAccessRPC(ctx, req.Id, ActionRead); err != nil {
+			return err
+		}
+		logger.Infof("Watching service with ID: %s", req.Id)
+	}
+	caller := rpcCallerID(ctx)
+
+	events := changes.subscribe(ctx)
+
+	if req.Id != "" {
+		if err := sendEvent(stream, currentState(req.Id)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if req.Id != "" && ev.Type != EventReset && ev.ID != req.Id {
+				continue
+			}
+			if req.Id == "" && !canWatch(ctx, caller, ev) {
+				continue
+			}
+			if err := sendEvent(stream, ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendEvent sends ev as it is sent to SSE watchers
+func sendEvent(stream grpcapi.Injector_WatchServer, ev Event) error {
+	w := newWatchEvent(ev)
+	pb := &grpcapi.Event{Type: string(w.Type), Id: w.ID, Revision: w.Revision}
+	if w.Service != nil {
+		var err error
+		if pb.Service, err = serviceProto(*w.Service); err != nil {
+			return err
+		}
+	}
+	return stream.Send(pb)
+}
+
+// serviceProto converts a descriptor to its gRPC message
+func serviceProto(s Service) (*grpcapi.Service, error) {
+	pb := &grpcapi.Service{
+		Id:             s.ID,
+		ServiceName:    s.ServiceName,
+		ServiceAddress: s.ServiceAddress,
+		Kind:           s.Kind,
+		Revision:       s.Revision,
+		Endpoints:      s.Endpoints,
+		Strategy:       s.Strategy,
+		Variant:        s.Variant,
+	}
+	for _, v := range s.Variants {
+		pb.Variants = append(pb.Variants, &grpcapi.Variant{Name: v.Name, ServiceAddress: v.ServiceAddress, Weight: v.Weight})
+	}
+	if s.Expires != nil {
+		pb.Expires = timestamppb.New(*s.Expires)
+	}
+	if len(s.Attributes) > 0 {
+		// Through JSON, attributes read from Mongo may hold BSON types
+		data, err := json.Marshal(s.Attributes)
+		if err != nil {
+			logger.Infof("Error encoding attributes of '%s': %v", s.ID, err)
+			return nil, status.Error(codes.Internal, "failed to encode service")
+		}
+		pb.Attributes = new(structpb.Struct)
+		if err := pb.Attributes.UnmarshalJSON(data); err != nil {
+			logger.Infof("Error encoding attributes of '%s': %v", s.ID, err)
+			return nil, status.Error(codes.Internal, "failed to encode service")
+		}
+	}
+	return pb, nil
+}
+
+// serviceFromProto converts a gRPC message to a descriptor. Whole numbers
+// among the attributes become integers, as they do in the HTTP API.
+func serviceFromProto(pb *grpcapi.Service) (Service, error) {
+	if pb == nil {
+		return Service{}, errors.New("no service given")
+	}
+	s := Service{
+		ID:             pb.Id,
+		ServiceName:    pb.ServiceName,
+		ServiceAddress: pb.ServiceAddress,
+		Kind:           pb.Kind,
+		Revision:       pb.Revision,
+		Endpoints:      pb.Endpoints,
+		Strategy:       pb.Strategy,
+	}
+	for _, v := range pb.Variants {
+		s.Variants = append(s.Variants, Variant{Name: v.Name, ServiceAddress: v.ServiceAddress, Weight: v.Weight})
+	}
+	if len(pb.Attributes.GetFields()) > 0 {
+		data, err := protojson.Marshal(pb.Attributes)
+		if err != nil {
+			return Service{}, err
+		}
+		if s.Attributes, err = decodeJSONObject(data); err != nil {
+			return Service{}, err
+		}
+		for k := range s.Attributes {
+			if reservedKeys[k] {
+				return Service{}, fmt.Errorf("attribute %s is a descriptor field", k)
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"injectorsdk"
+)
+
+// clockSkew is how far exp and nbf may be off between issuer and injector
+const clockSkew = 30 * time.Second
+
+// jwtHashes maps the supported signing algorithms to their hash. "none" is
+// deliberately missing.
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// esCurves ties each ECDSA algorithm to its curve (RFC 7518, section 3.4),
+// a key on another curve must not verify it
+var esCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521(),
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims the injector checks, plus the scopes
+// either as an OAuth scope string or as a list
+type jwtClaims struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  audience   `json:"aud"`
+	ExpiresAt int64      `json:"exp"`
+	NotBefore int64      `json:"nbf"`
+	Scope     string     `json:"scope"`
+	Scopes    []string   `json:"scp"`
+	K8s       *k8sClaims `json:"kubernetes.io"`
+}
+
+// k8sClaims are carried by projected service account tokens
+type k8sClaims struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount struct {
+		Name string `json:"name"`
+	} `json:"serviceaccount"`
+}
+
+// audience accepts both forms the spec allows, a string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// verifyJWT checks the signature of token with hmacKey or a key from jwks,
+// whichever its algorithm calls for, and returns its claims
+func verifyJWT(token string, hmacKey []byte, jwks *jwksFile) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed header: %w", err)
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return jwtClaims{}, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed signature: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(hmacKey) == 0 {
+			return jwtClaims{}, errors.New("HMAC tokens are not accepted")
+		}
+		mac := hmac.New(hash.New, hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return jwtClaims{}, errors.New("invalid signature")
+		}
+	} else {
+		if jwks == nil {
+			return jwtClaims{}, errors.New("no JWKS configured")
+		}
+		h := hash.New()
+		h.Write(signed)
+		if !jwks.verify(header, h.Sum(nil), hash, sig) {
+			return jwtClaims{}, errors.New("invalid signature")
+		}
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed claims: %w", err)
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 {
+		return jwtClaims{}, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return jwtClaims{}, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return jwtClaims{}, errors.New("token not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwksFile holds the public keys of a JWKS document on disk, e.g. the
+// cluster's service account issuer keys from /openid/v1/jwks mounted from a
+// ConfigMap. The file is read again when it changes, so keys can rotate
+// without a restart.
+type jwksFile struct {
+	keys *injectorsdk.Reloader[[]jwk]
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	key crypto.PublicKey
+}
+
+func newJWKSFile(path string) (*jwksFile, error) {
+	keys, err := injectorsdk.NewReloader(reloadCheckInterval, func() ([]jwk, error) {
+		return loadJWKS(path)
+	}, path)
+	if err != nil {
+		return nil, err
+	}
+	return &jwksFile{keys: keys}, nil
+}
+
+// loadJWKS reads the signing keys in the JWKS document at path
+func loadJWKS(path string) ([]jwk, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS %s: %w", path, err)
+	}
+
+	keys := make([]jwk, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Infof("Skipping JWKS key '%s': %v", k.Kid, err)
+			continue
+		}
+		k.key = key
+		keys = append(keys, k)
+	}
+	logger.Infof("Loaded %d keys from %s", len(keys), path)
+	return keys, nil
+}
+
+// verify checks sig over digest with the key named by the token's kid, or
+// with every key of a matching type if it names none
+func (f *jwksFile) verify(header jwtHeader, digest []byte, hash crypto.Hash, sig []byte) bool {
+	keys, err := f.keys.Get()
+	if err != nil {
+		// Keep verifying with the keys we have
+		logger.Infof("%v", err)
+	}
+
+	for _, k := range keys {
+		if header.Kid != "" && k.Kid != header.Kid {
+			continue
+		}
+		if k.Alg != "" && k.Alg != header.Alg {
+			continue
+		}
+		switch key := k.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(header.Alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if esCurves[header.Alg] == key.Curve && verifyES(key, digest, sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyES checks a JWS ECDSA signature, the two integers r and s
+// concatenated at the curve's size
+func verifyES(key *ecdsa.PublicKey, digest, sig []byte) bool {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	return ecdsa.Verify(key, digest, r, s)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
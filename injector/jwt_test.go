@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signJWT signs claims as a JWS with alg, using an HMAC secret, an RSA or
+// an ECDSA key
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := jwtHashes[alg]
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kid": kid, "kty": "RSA", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid, "kty": "EC", "use": "sig", "crv": key.Curve.Params().Name,
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	secret := []byte("shared-secret")
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa", rsaKey), ecJWK("p256", p256), ecJWK("p384", p384))
+	jwks, err := newJWKSFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticator{hmacKey: secret, jwks: jwks, issuer: "https://issuer", audience: "injector", defaultScopes: []string{ScopeRead}}
+
+	claims := func(edit func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "fn", "iss": "https://issuer", "aud": "injector",
+			"exp": time.Now().Add(time.Hour).Unix(), "scope": "services:write",
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", signJWT(t, "HS256", "", secret, claims(nil)), true},
+		{"RS256", signJWT(t, "RS256", "rsa", rsaKey, claims(nil)), true},
+		{"ES256 on P-256", signJWT(t, "ES256", "p256", p256, claims(nil)), true},
+		{"ES384 on P-384", signJWT(t, "ES384", "p384", p384, claims(nil)), true},
+		{"ES256 without kid", signJWT(t, "ES256", "", p256, claims(nil)), true},
+		{"alg none", signJWT(t, "none", "", secret, claims(nil)), false},
+		{"wrong HMAC secret", signJWT(t, "HS256", "", []byte("other"), claims(nil)), false},
+		{"RS256 signed with HMAC", signJWT(t, "RS256", "rsa", secret, claims(nil)), false},
+		{"ES384 on P-256", signJWT(t, "ES384", "p256", p256, claims(nil)), false},
+		{"ES256 on P-384", signJWT(t, "ES256", "p384", p384, claims(nil)), false},
+		{"RS256 naming an EC key", signJWT(t, "RS256", "p256", rsaKey, claims(nil)), false},
+		{"unknown kid", signJWT(t, "RS256", "gone", rsaKey, claims(nil)), false},
+		{"expired", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+		})), false},
+		{"no expiry", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			delete(c, "exp")
+		})), false},
+		{"not valid yet", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			c["nbf"] = time.Now().Add(time.Hour).Unix()
+		})), false},
+		{"wrong issuer", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			c["iss"] = "https://other"
+		})), false},
+		{"wrong audience", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"other", "another"}
+		})), false},
+		{"audience in a list", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"other", "injector"}
+		})), true},
+		{"no subject", signJWT(t, "RS256", "rsa", rsaKey, claims(func(c map[string]interface{}) {
+			delete(c, "sub")
+		})), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Authenticate(tt.token)
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWTScopes(t *testing.T) {
+	secret := []byte("shared-secret")
+	a := &Authenticator{hmacKey: secret, defaultScopes: []string{ScopeRead}}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		read, write bool
+	}{
+		{"scope string", map[string]interface{}{"sub": "fn", "exp": exp, "scope": "services:write"}, true, true},
+		{"scope list", map[string]interface{}{"sub": "fn", "exp": exp, "scp": []string{"services:read"}}, true, false},
+		{"other scope", map[string]interface{}{"sub": "fn", "exp": exp, "scope": "profile"}, false, false},
+		{"default scopes", map[string]interface{}{"sub": "fn", "exp": exp}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(signJWT(t, "HS256", "", secret, tt.claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.has(ScopeRead) != tt.read || id.has(ScopeWrite) != tt.write {
+				t.Fatalf("scopes %v: read %v write %v, want read %v write %v", id.Scopes, id.has(ScopeRead), id.has(ScopeWrite), tt.read, tt.write)
+			}
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	// Check the file on every verification
+	defer func(interval time.Duration) { reloadCheckInterval = interval }(reloadCheckInterval)
+	reloadCheckInterval = 0
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("old", oldKey))
+	jwks, err := newJWKSFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticator{jwks: jwks, defaultScopes: []string{ScopeRead}}
+	claims := map[string]interface{}{"sub": "fn", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if _, err := a.Authenticate(signJWT(t, "RS256", "old", oldKey, claims)); err != nil {
+		t.Fatalf("old key rejected before the rotation: %v", err)
+	}
+
+	writeJWKS(t, path, rsaJWK("new", newKey))
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(signJWT(t, "RS256", "new", newKey, claims)); err != nil {
+		t.Fatalf("new key rejected after the rotation: %v", err)
+	}
+	if _, err := a.Authenticate(signJWT(t, "RS256", "old", oldKey, claims)); err == nil {
+		t.Fatal("old key accepted after the rotation")
+	}
+}
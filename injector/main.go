@@ -2,28 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"injectorsdk"
+
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/sirupsen/logrus"
 )
 
-type Service struct {
-	id             string `json:"id" bson:"id"`
-	ServiceName    string `json:"ServiceName" bson:"ServiceName"`
-	ServiceAddress string `json:"ServiceAddress" bson:"ServiceAddress"`
-}
-
-var collection *mongo.Collection
-var cache sync.Map
+var store Store
+var cache *injectorsdk.Cache[Service]
+var lookups singleflight.Group
 
 var logger = logrus.New()
 
@@ -40,79 +41,490 @@ func (f *CSVFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 }
 
 func main() {
+	// injector ca ... issues test certificates instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "ca" {
+		os.Exit(caCommand(os.Args[2:]))
+	}
+
 	logger.SetOutput(os.Stdout)
 	logger.SetLevel(logrus.InfoLevel)    // Log level
 	logger.SetFormatter(&CSVFormatter{}) // Use custom CSV formatter
 
 	// Get env vars
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://mongo:27017"
-	}
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "5000"
 	}
 
-	// Connect to MongoDB
+	// Open the storage backend
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	var err error
+	store, err = newStoreFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("Failed to open store: %v", err)
+	}
+	logger.Infof("Store opened")
+
+	// Resolution cache
+	cache = injectorsdk.NewCache[Service](
+		durationEnv("CACHE_TTL", 10*time.Minute),
+		durationEnv("CACHE_NEGATIVE_TTL", 5*time.Second),
+		intEnv("CACHE_MAX_ENTRIES", 10000),
+	)
+
+	// Dependencies of the function served by a sidecar injector, see /ready
+	if path := os.Getenv("INJECTOR_MANIFEST"); path != "" {
+		deps, err := loadManifest(path)
+		if err != nil {
+			logger.Fatalf("Failed to load dependency manifest: %v", err)
+		}
+		readiness.pending = deps
+	}
+
+	// Short-lived credentials for MinIO descriptors
+	broker, err = newBrokerFromEnv()
 	if err != nil {
-		logger.Infof("MongoDB connection error: %v", err)
+		logger.Fatalf("Failed to set up credential broker: %v", err)
+	}
+	credentialTTL = durationEnv("CREDENTIAL_TTL", 15*time.Minute)
+	if err := validateCredentialTTL(credentialTTL); err != nil {
+		logger.Fatalf("Failed to set up credential broker: %v", err)
 	}
-	collection = client.Database("services").Collection("services")
-	logger.Infof("Connected to MongoDB")
 
-	// Gin router
+	// Keep the cache in sync with changes made behind the API's back
+	go watchStore(context.Background(), store, durationEnv("WATCH_POLL_INTERVAL", 5*time.Second))
+
+	// Optional TLS, with client certificates identifying callers in mTLS mode
+	certs, err := newServerCertsFromEnv()
+	if err != nil {
+		logger.Fatalf("Failed to set up TLS: %v", err)
+	}
+
+	// Bearer token authentication, off unless configured
+	auth, err = newAuthenticatorFromEnv()
+	if err != nil {
+		logger.Fatalf("Failed to set up authentication: %v", err)
+	}
+
+	// Which callers may resolve and change which services, off unless configured
+	authz, err = newAuthorizerFromEnv()
+	if err != nil {
+		logger.Fatalf("Failed to set up authorization: %v", err)
+	}
+	if authz != nil && auth == nil && !mtls {
+		logger.Warnf("Authorization is on without authentication, callers are trusted to send their own X-Caller-ID")
+	}
+
+	// Gin router. Probes stay unauthenticated.
 	r := gin.Default()
-	r.GET("/services/:id", getServiceHandler)
 	r.GET("/health", healthCheckHandler)
+	r.GET("/ready", readyHandler)
+
+	read := r.Group("/", requireScope(ScopeRead))
+	read.GET("/services", listServicesHandler)
+	read.GET("/services/:id", getServiceHandler)
+	read.GET("/services/:id/watch", watchServiceHandler)
+	read.GET("/services/:id/history", historyHandler)
+	read.GET("/watch", watchServicesHandler)
+	read.GET("/cache/stats", cacheStatsHandler)
+
+	write := r.Group("/", requireScope(ScopeWrite))
+	write.POST("/services", createServiceHandler)
+	write.PUT("/services/:id", replaceServiceHandler)
+	write.PATCH("/services/:id", updateServiceHandler)
+	write.DELETE("/services/:id", deleteServiceHandler)
+	write.POST("/services/:id/rollback", rollbackHandler)
+
+	// gRPC API on its own port
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "5001"
+	}
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		logger.Fatalf("Failed to listen on gRPC port: %v", err)
+	}
+	go func() {
+		logger.Infof("Injector gRPC API running on port %s", grpcPort)
+		opts := []grpc.ServerOption{grpc.UnaryInterceptor(authUnaryInterceptor), grpc.StreamInterceptor(authStreamInterceptor)}
+		if certs != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certs.config("h2"))))
+		}
+		if err := newGRPCServer(opts...).Serve(lis); err != nil {
+			logger.Infof("Failed to run gRPC server: %v", err)
+		}
+	}()
 
-	logger.Infof("Injector API running on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+	// Optionally also serve HTTP on a Unix socket, for callers in the same
+	// pod (sidecar) or on the same node (DaemonSet with a hostPath mount)
+	if socketPath := os.Getenv("SOCKET_PATH"); socketPath != "" {
+		// A socket left over from a previous run would make Listen fail
+		os.Remove(socketPath)
+		ul, err := net.Listen("unix", socketPath)
+		if err != nil {
+			logger.Fatalf("Failed to listen on %s: %v", socketPath, err)
+		}
+		// Callers may run as a different user than the injector
+		if err := os.Chmod(socketPath, 0666); err != nil {
+			logger.Infof("Failed to make %s accessible: %v", socketPath, err)
+		}
+		go func() {
+			logger.Infof("Injector API running on %s", socketPath)
+			if err := http.Serve(ul, r); err != nil {
+				logger.Infof("Failed to run server on %s: %v", socketPath, err)
+			}
+		}()
+	}
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	if certs != nil {
+		srv.TLSConfig = certs.config("h2", "http/1.1")
+		logger.Infof("Injector API running on port %s with TLS", port)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		logger.Infof("Injector API running on port %s", port)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		logger.Infof("Failed to run server: %v", err)
 	}
 }
 
 func getServiceHandler(c *gin.Context) {
 	id := c.Param("id")
+	if _, ok := c.GetQuery("revision"); ok {
+		getRevisionHandler(c)
+		return
+	}
 	logger.Infof("Fetching service with ID: %s", id)
 
 	start := time.Now()
 
-	// Check if the service is in cache
-	if val, ok := cache.Load(id); ok {
-		end := time.Now()
-		logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
-		c.JSON(200, val)
+	service, err := serveService(c.Request.Context(), callerID(c), id, routingKey(c))
+	if err != nil {
+		c.JSON(httpStatus(err), gin.H{"error": errorMessage(err)})
 		return
 	}
+	if service.Variant != "" {
+		c.Header("X-Service-Variant", service.Variant)
+	}
+
+	end := time.Now()
+	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
+
+	c.JSON(http.StatusOK, service)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// ErrCredentials is returned by serveService when no credentials could be
+// minted for a descriptor
+var ErrCredentials = errors.New("failed to mint credentials")
+
+// serveService is one resolution of id for caller, the same for the HTTP and
+// gRPC APIs: the access check, the lookup, then the variant for key, the
+// endpoint and the credentials the caller is served
+func serveService(ctx context.Context, caller, id, key string) (Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var service Service
+	if err := checkAccess(ctx, caller, id, ActionResolve); err != nil {
+		return Service{}, err
+	}
+	service, err := resolve(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			logger.Infof("Error finding service with id '%s': %v", id, err)
+		}
+		return Service{}, err
+	}
+	return prepareService(ctx, caller, service, key)
+}
 
-	// Find the service in MongoDB
-	err := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}}).Decode(&service)
+// prepareService turns a resolved descriptor into the one served to caller
+func prepareService(ctx context.Context, caller string, service Service, key string) (Service, error) {
+	service = pickEndpoint(pickVariant(service, key))
+	if service.Variant != "" {
+		logger.Infof("Serving variant '%s' of '%s'", service.Variant, service.ID)
+	}
+	served, err := withCredentials(service, caller)
 	if err != nil {
-		logger.Infof("Error finding service with id '%s': %v", id, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		logger.Infof("Error minting credentials for '%s': %v", service.ID, err)
+		return Service{}, ErrCredentials
+	}
+	return served, nil
+}
+
+// errorMessage is what callers are told about a failed resolution
+func errorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "service not found"
+	case errors.Is(err, ErrDenied):
+		return "access denied"
+	case errors.Is(err, ErrAuthzUnavailable):
+		return "authorization unavailable"
+	case errors.Is(err, ErrCredentials):
+		return "failed to mint credentials"
+	default:
+		return "failed to fetch service"
+	}
+}
+
+// httpStatus is the status code of a failed resolution
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAuthzUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrCredentials):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// BatchResult is the response of GET /services?ids=a,b,c
+type BatchResult struct {
+	Services map[string]Service `json:"services"`
+	Errors   map[string]string  `json:"errors,omitempty"`
+	// Statuses has the status GET /services/:id would answer with for each
+	// id in Errors, so callers need not interpret the messages
+	Statuses map[string]int `json:"statuses,omitempty"`
+	// failures holds the errors behind Errors, for the gRPC API's codes
+	failures map[string]error
+}
+
+// fail records why id could not be served
+func (r *BatchResult) fail(id string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+		r.Statuses = make(map[string]int)
+		r.failures = make(map[string]error)
+	}
+	r.Errors[id] = errorMessage(err)
+	r.Statuses[id] = httpStatus(err)
+	r.failures[id] = err
+}
+
+// maxBatchSize bounds the number of ids a single batch request may ask for
+const maxBatchSize = 100
+
+func batchGetHandler(c *gin.Context) {
+	ids := splitIDs(c.Query("ids"))
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no ids given"})
+		return
+	}
+	if len(ids) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d ids per request", maxBatchSize)})
 		return
 	}
+	logger.Infof("Fetching %d services in batch", len(ids))
 
+	start := time.Now()
+	result := serveBatch(c.Request.Context(), callerID(c), ids, routingKey(c))
 	end := time.Now()
-	logger.Infof("Service retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
-	// Store in cache
-	cache.Store(id, service)
+	logger.Infof("Services retrieved in %.3f ms", float64(end.Sub(start).Nanoseconds())/1e6)
 
-	c.JSON(http.StatusOK, service)
+	c.JSON(http.StatusOK, result)
+}
+
+// serveBatch is serveService for several ids, with an error for every id
+// that could not be served
+func serveBatch(ctx context.Context, caller string, ids []string, key string) BatchResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	allowed, denied := checkAccessAll(ctx, caller, ids, ActionResolve)
+	result := resolveBatch(allowed)
+	for id, err := range denied {
+		result.fail(id, err)
+	}
+	for id, service := range result.Services {
+		service, err := prepareService(ctx, caller, service, key)
+		if err != nil {
+			delete(result.Services, id)
+			result.fail(id, err)
+			continue
+		}
+		result.Services[id] = service
+	}
+	return result
+}
+
+// resolveBatch resolves every id concurrently, collecting per-id errors
+func resolveBatch(ids []string) BatchResult {
+	result := BatchResult{Services: make(map[string]Service, len(ids))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			service, err := resolve(id)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !errors.Is(err, ErrNotFound) {
+					logger.Infof("Error finding service with id '%s': %v", id, err)
+				}
+				result.fail(id, err)
+				return
+			}
+			result.Services[id] = service
+		}(id)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// splitIDs parses a comma separated id list, dropping blanks and duplicates
+func splitIDs(list string) []string {
+	return uniqueIDs(strings.Split(list, ","))
+}
+
+func uniqueIDs(list []string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range list {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// resolve returns the service for id from the cache, falling back to the
+// store. Concurrent misses for the same id share a single store lookup.
+func resolve(id string) (Service, error) {
+	// Check if the service is in cache
+	if service, notFound, ok := cache.Get(id); ok {
+		if notFound {
+			return Service{}, ErrNotFound
+		}
+		return service, nil
+	}
+
+	v, err, shared := lookups.Do(id, func() (interface{}, error) {
+		// Not tied to any one request, the result is handed to every waiter
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Find the service in the store
+		gen := generation(id)
+		service, err := store.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			cacheIfCurrent(id, gen, func() { cache.SetNotFound(id) })
+			return Service{}, err
+		}
+		if err != nil {
+			return Service{}, err
+		}
+
+		// Store in cache
+		cacheIfCurrent(id, gen, func() { cache.Set(id, service) })
+		return service, nil
+	})
+	if shared {
+		logger.Infof("Lookup for '%s' shared with concurrent requests", id)
+	}
+
+	return v.(Service), err
+}
+
+// generations counts the invalidations of each id, and epoch those of the
+// whole cache. A lookup only caches what it read if neither changed
+// meanwhile, or a lookup that started before a write could put back the
+// descriptor the write replaced.
+var generations = struct {
+	sync.Mutex
+	ids   map[string]uint64
+	epoch uint64
+}{ids: make(map[string]uint64)}
+
+func generation(id string) uint64 {
+	generations.Lock()
+	defer generations.Unlock()
+	return generations.epoch + generations.ids[id]
+}
+
+// cacheIfCurrent runs set unless id was invalidated since gen was taken
+func cacheIfCurrent(id string, gen uint64, set func()) {
+	generations.Lock()
+	defer generations.Unlock()
+	if generations.epoch+generations.ids[id] == gen {
+		set()
+	}
+}
+
+// invalidate drops id from the cache after a change. A lookup of id still
+// in flight neither caches its result nor hands it to later requests.
+func invalidate(id string) {
+	generations.Lock()
+	generations.ids[id]++
+	cache.Delete(id)
+	generations.Unlock()
+	lookups.Forget(id)
+}
+
+// refreshCached is invalidate for a change that carries the new descriptor,
+// which replaces the cached entry rather than dropping it
+func refreshCached(id string, service Service) {
+	generations.Lock()
+	generations.ids[id]++
+	cache.Refresh(id, service)
+	generations.Unlock()
+	lookups.Forget(id)
+}
+
+// invalidateAll drops the whole cache, when changes may have been missed
+func invalidateAll() {
+	generations.Lock()
+	generations.epoch++
+	cache.Clear()
+	generations.Unlock()
 }
 
 func healthCheckHandler(c *gin.Context) {
 	logger.Infof("Health check endpoint hit")
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
+
+func cacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, cache.Stats())
+}
+
+// durationEnv reads a time.Duration such as "30s" from the environment
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logger.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d
+}
+
+func intEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Fatalf("Invalid %s: %v", name, err)
+	}
+	return n
+}
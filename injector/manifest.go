@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"injectorsdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readiness tracks the required dependencies of the function next to a
+// sidecar injector. Once all of them resolved it stays ready.
+var readiness struct {
+	mu      sync.Mutex
+	pending []injectorsdk.Dependency
+	ready   bool
+}
+
+// loadManifest reads the required dependencies from the manifest at path,
+// parsed and checked the way the function's SDK does
+func loadManifest(path string) ([]injectorsdk.Dependency, error) {
+	m, err := injectorsdk.LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var required []injectorsdk.Dependency
+	for _, dep := range m.Dependencies {
+		if !dep.Optional {
+			required = append(required, dep)
+		}
+	}
+	return required, nil
+}
+
+// readyHandler answers 200 once every required dependency in the manifest
+// resolves, 503 with the ones still missing before that. Each probe retries
+// the missing ones in one batch, which also warms the cache.
+func readyHandler(c *gin.Context) {
+	readiness.mu.Lock()
+	ready, deps := readiness.ready, readiness.pending
+	readiness.mu.Unlock()
+
+	if ready {
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+		return
+	}
+
+	// Resolved without the lock, so a slow registry does not queue up the
+	// probes behind each other
+	ids := make([]string, len(deps))
+	for i, dep := range deps {
+		ids[i] = dep.ID
+	}
+	result := resolveBatch(ids)
+
+	var pending []injectorsdk.Dependency
+	missing := make(map[string]string)
+	for _, dep := range deps {
+		service, ok := result.Services[dep.ID]
+		switch {
+		case !ok:
+			missing[dep.ID] = result.Errors[dep.ID]
+		case dep.Kind != "" && service.Kind != dep.Kind:
+			missing[dep.ID] = fmt.Sprintf("kind is %q, manifest wants %q", service.Kind, dep.Kind)
+		default:
+			continue
+		}
+		pending = append(pending, dep)
+	}
+
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+	if readiness.ready {
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+		return
+	}
+	readiness.pending = pending
+
+	if len(pending) > 0 {
+		logger.Infof("Waiting for %d dependencies", len(pending))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "dependencies not resolved", "missing": missing})
+		return
+	}
+	readiness.ready = true
+	logger.Infof("All dependencies resolved")
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
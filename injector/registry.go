@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Service ids end up in URL paths, so keep them to a conservative charset
+var serviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ServicePatch is a JSON merge patch: keys present in the body replace the
+// descriptor's values and attributes set to null are removed
+type ServicePatch map[string]interface{}
+
+func (p *ServicePatch) UnmarshalJSON(data []byte) error {
+	obj, err := decodeJSONObject(data)
+	if err != nil {
+		return err
+	}
+	*p = obj
+	return nil
+}
+
+func (p ServicePatch) apply(s *Service) error {
+	for k, v := range p {
+		if k == "Revision" || k == "Variant" || k == "Expires" {
+			// Assigned by the store, or per resolution
+			continue
+		}
+		if k == "Variants" {
+			variants, err := decodeVariants(v)
+			if err != nil {
+				return err
+			}
+			s.Variants = variants
+			continue
+		}
+		if k == "Endpoints" {
+			endpoints, err := decodeEndpoints(v)
+			if err != nil {
+				return err
+			}
+			s.Endpoints = endpoints
+			continue
+		}
+		if !reservedKeys[k] {
+			if v == nil {
+				delete(s.Attributes, k)
+				continue
+			}
+			if s.Attributes == nil {
+				s.Attributes = make(map[string]interface{})
+			}
+			s.Attributes[k] = v
+			continue
+		}
+
+		str, ok := v.(string)
+		if !ok && !((k == "Kind" || k == "Strategy") && v == nil) {
+			return fmt.Errorf("%s must be a string", k)
+		}
+		switch k {
+		case "id", "_id":
+			if str != s.ID {
+				return errors.New("id cannot be changed")
+			}
+		case "ServiceName":
+			s.ServiceName = str
+		case "ServiceAddress":
+			s.ServiceAddress = str
+		case "Kind":
+			s.Kind = str
+		case "Strategy":
+			s.Strategy = str
+		}
+	}
+	return nil
+}
+
+func validateService(s Service) error {
+	if !serviceIDPattern.MatchString(s.ID) {
+		return fmt.Errorf("invalid id %q", s.ID)
+	}
+	if strings.TrimSpace(s.ServiceName) == "" {
+		return errors.New("ServiceName is required")
+	}
+	// Each resolution of a service with endpoints serves one of them as its
+	// ServiceAddress, so it needs none of its own
+	if strings.TrimSpace(s.ServiceAddress) == "" && len(s.Endpoints) == 0 {
+		return errors.New("ServiceAddress or Endpoints is required")
+	}
+	for k := range s.Attributes {
+		if k == "" || strings.HasPrefix(k, "$") || strings.Contains(k, ".") {
+			return fmt.Errorf("invalid attribute name %q", k)
+		}
+	}
+	if err := validateSecretRefs(s); err != nil {
+		return err
+	}
+	if err := validateEndpoints(s.Endpoints, s.Strategy); err != nil {
+		return err
+	}
+	return validateVariants(s.Variants)
+}
+
+func listServicesHandler(c *gin.Context) {
+	if _, ok := c.GetQuery("ids"); ok {
+		batchGetHandler(c)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	services, err := store.List(ctx)
+	if err != nil {
+		logger.Infof("Error listing services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list services"})
+		return
+	}
+
+	// Services the caller may not read are left out rather than failing
+	// the whole listing
+	if authz != nil {
+		caller := callerID(c)
+		visible := services[:0]
+		for _, service := range services {
+			if checkAccess(ctx, caller, service.ID, ActionRead) == nil {
+				visible = append(visible, service)
+			}
+		}
+		services = visible
+	}
+
+	c.JSON(http.StatusOK, redactAll(services))
+}
+
+func createServiceHandler(c *gin.Context) {
+	var service Service
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if err := validateService(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorize(c, service.ID, ActionWrite) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service, err := store.Put(ctx, service, PutCreate)
+	if err != nil {
+		if errors.Is(err, ErrExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "service already exists"})
+			return
+		}
+		logger.Infof("Error registering service '%s': %v", service.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register service"})
+		return
+	}
+
+	invalidate(service.ID)
+	logger.Infof("Service '%s' registered", service.ID)
+	c.JSON(http.StatusCreated, service)
+}
+
+func replaceServiceHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionWrite) {
+		return
+	}
+
+	var service Service
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if service.ID == "" {
+		service.ID = id
+	}
+	if service.ID != id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id in body does not match path"})
+		return
+	}
+	if err := validateService(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service, err := store.Put(ctx, service, PutUpdate)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error replacing service '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update service"})
+		return
+	}
+
+	invalidate(id)
+	logger.Infof("Service '%s' replaced", id)
+	c.JSON(http.StatusOK, service)
+}
+
+func updateServiceHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionWrite) {
+		return
+	}
+
+	var patch ServicePatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service, err := store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error finding service '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update service"})
+		return
+	}
+
+	if err := patch.apply(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateService(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	service, err = store.Put(ctx, service, PutUpdate)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error updating service '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update service"})
+		return
+	}
+
+	invalidate(id)
+	logger.Infof("Service '%s' updated", id)
+	c.JSON(http.StatusOK, service)
+}
+
+func deleteServiceHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionWrite) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := store.Delete(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error deleting service '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete service"})
+		return
+	}
+
+	invalidate(id)
+	logger.Infof("Service '%s' deleted", id)
+	c.Status(http.StatusNoContent)
+}
+
+// getRevisionHandler serves GET /services/:id?revision=N. Old revisions are
+// immutable and read straight from the store, bypassing the cache.
+func getRevisionHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionRead) {
+		return
+	}
+	revision, err := strconv.ParseInt(c.Query("revision"), 10, 64)
+	if err != nil || revision < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision must be a positive integer"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service, err := store.GetRevision(ctx, id, revision)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error finding revision %d of '%s': %v", revision, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch service"})
+		return
+	}
+
+	c.JSON(http.StatusOK, redact(service))
+}
+
+func historyHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionRead) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	history, err := store.History(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error listing revisions of '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, redactAll(history))
+}
+
+// rollbackHandler re-promotes an earlier revision, given as ?revision=N. The
+// old descriptor is stored again under a new revision, so history is only
+// ever appended to. This also restores a deleted service.
+func rollbackHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !authorize(c, id, ActionWrite) {
+		return
+	}
+	revision, err := strconv.ParseInt(c.Query("revision"), 10, 64)
+	if err != nil || revision < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision must be a positive integer"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target, err := store.GetRevision(ctx, id, revision)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error finding revision %d of '%s': %v", revision, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to roll back service"})
+		return
+	}
+
+	service, err := store.Put(ctx, target, PutUpsert)
+	if errors.Is(err, ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Infof("Error rolling back service '%s': %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to roll back service"})
+		return
+	}
+
+	invalidate(id)
+	logger.Infof("Service '%s' rolled back to revision %d as revision %d", id, revision, service.Revision)
+	c.JSON(http.StatusOK, service)
+}
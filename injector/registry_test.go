@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"injectorsdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRegistryRouter serves the registry API from a fresh memory store, with
+// authentication and authorization off
+func newRegistryRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	cache = injectorsdk.NewCache[Service](time.Minute, time.Second, 100)
+
+	r := gin.New()
+	r.GET("/services", listServicesHandler)
+	r.GET("/services/:id", getServiceHandler)
+	r.GET("/services/:id/history", historyHandler)
+	r.POST("/services", createServiceHandler)
+	r.PUT("/services/:id", replaceServiceHandler)
+	r.PATCH("/services/:id", updateServiceHandler)
+	r.DELETE("/services/:id", deleteServiceHandler)
+	r.POST("/services/:id/rollback", rollbackHandler)
+	return r
+}
+
+// call sends body, if any, to r and returns the status and response body
+func call(r http.Handler, method, path, body string) (int, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestRegistryStatusCodes(t *testing.T) {
+	r := newRegistryRouter(t)
+	hello := `{"id":"hello","ServiceName":"hello","ServiceAddress":"http://hello"}`
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/services", hello, http.StatusCreated},
+		{http.MethodPost, "/services", hello, http.StatusConflict},
+		{http.MethodPost, "/services", `{"id":"bad id","ServiceName":"x","ServiceAddress":"http://x"}`, http.StatusBadRequest},
+		{http.MethodPost, "/services", `{"id":`, http.StatusBadRequest},
+		{http.MethodGet, "/services/hello", "", http.StatusOK},
+		{http.MethodPut, "/services/hello", `{"ServiceName":"hello","ServiceAddress":"http://hello-2"}`, http.StatusOK},
+		{http.MethodPut, "/services/hello", `{"id":"other","ServiceName":"hello","ServiceAddress":"http://hello"}`, http.StatusBadRequest},
+		{http.MethodPut, "/services/missing", `{"ServiceName":"missing","ServiceAddress":"http://missing"}`, http.StatusNotFound},
+		{http.MethodPatch, "/services/hello", `{"Region":"eu"}`, http.StatusOK},
+		{http.MethodPatch, "/services/hello", `{"id":"other"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/services/missing", `{"Region":"eu"}`, http.StatusNotFound},
+		{http.MethodDelete, "/services/hello", "", http.StatusNoContent},
+		{http.MethodDelete, "/services/hello", "", http.StatusNotFound},
+		{http.MethodGet, "/services/hello", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if code, body := call(r, step.method, step.path, step.body); code != step.want {
+			t.Fatalf("%s %s %s returned %d, want %d: %s", step.method, step.path, step.body, code, step.want, body)
+		}
+	}
+}
+
+func TestPatchRemovesAttribute(t *testing.T) {
+	r := newRegistryRouter(t)
+	code, body := call(r, http.MethodPost, "/services", `{"id":"minio","ServiceName":"minio","ServiceAddress":"http://minio:9000","Bucket":"data","Region":"eu"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", code, body)
+	}
+
+	code, body = call(r, http.MethodPatch, "/services/minio", `{"Region":null,"ServiceAddress":"http://minio:9001"}`)
+	if code != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", code, body)
+	}
+	var patched map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &patched); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := patched["Region"]; ok {
+		t.Fatal("Region kept after patching it to null")
+	}
+	if patched["Bucket"] != "data" || patched["ServiceAddress"] != "http://minio:9001" {
+		t.Fatalf("patch lost or skipped fields: %s", body)
+	}
+
+	// The removal is stored, not only left out of the response
+	_, body = call(r, http.MethodGet, "/services/minio", "")
+	if strings.Contains(body, "Region") {
+		t.Fatalf("Region served after its removal: %s", body)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	r := newRegistryRouter(t)
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/services", `{"id":"hello","ServiceName":"hello","ServiceAddress":"http://hello-1"}`, http.StatusCreated},
+		{http.MethodPut, "/services/hello", `{"ServiceName":"hello","ServiceAddress":"http://hello-2"}`, http.StatusOK},
+		{http.MethodPost, "/services/hello/rollback?revision=0", "", http.StatusBadRequest},
+		{http.MethodPost, "/services/hello/rollback?revision=3", "", http.StatusNotFound},
+		{http.MethodPost, "/services/missing/rollback?revision=1", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if code, body := call(r, step.method, step.path, step.body); code != step.want {
+			t.Fatalf("%s %s returned %d, want %d: %s", step.method, step.path, code, step.want, body)
+		}
+	}
+
+	resolved := func(want string) {
+		t.Helper()
+		code, body := call(r, http.MethodGet, "/services/hello", "")
+		var service Service
+		if err := json.Unmarshal([]byte(body), &service); code != http.StatusOK || err != nil {
+			t.Fatalf("resolve returned %d: %s", code, body)
+		}
+		if service.ServiceAddress != want {
+			t.Fatalf("resolved %s, want %s", service.ServiceAddress, want)
+		}
+	}
+	resolved("http://hello-2")
+	if _, _, ok := cache.Get("hello"); !ok {
+		t.Fatal("resolution not cached")
+	}
+
+	// The old descriptor comes back as a new revision, and is served at once
+	code, body := call(r, http.MethodPost, "/services/hello/rollback?revision=1", "")
+	var rolledBack Service
+	if err := json.Unmarshal([]byte(body), &rolledBack); code != http.StatusOK || err != nil {
+		t.Fatalf("rollback returned %d: %s", code, body)
+	}
+	if rolledBack.Revision != 3 || rolledBack.ServiceAddress != "http://hello-1" {
+		t.Fatalf("rolled back to %+v, want revision 3 at http://hello-1", rolledBack)
+	}
+	resolved("http://hello-1")
+
+	_, body = call(r, http.MethodGet, "/services/hello/history", "")
+	var history []Service
+	if err := json.Unmarshal([]byte(body), &history); err != nil || len(history) != 3 {
+		t.Fatalf("history after the rollback: %s", body)
+	}
+	_, body = call(r, http.MethodGet, "/services/hello?revision=2", "")
+	if !strings.Contains(body, "http://hello-2") {
+		t.Fatalf("revision 2 served as %s", body)
+	}
+
+	// Rolling back restores a deleted service
+	if code, body := call(r, http.MethodDelete, "/services/hello", ""); code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", code, body)
+	}
+	if code, body := call(r, http.MethodPost, "/services/hello/rollback?revision=2", ""); code != http.StatusOK || !strings.Contains(body, `"Revision":4`) {
+		t.Fatalf("rollback after delete returned %d: %s", code, body)
+	}
+	resolved("http://hello-2")
+}
+
+func TestEndpointsWithoutAddress(t *testing.T) {
+	r := newRegistryRouter(t)
+	if code, body := call(r, http.MethodPost, "/services", `{"id":"none","ServiceName":"none"}`); code != http.StatusBadRequest {
+		t.Fatalf("descriptor without an address registered: %d %s", code, body)
+	}
+	code, body := call(r, http.MethodPost, "/services", `{"id":"opa","ServiceName":"opa","Endpoints":["http://opa-0","http://opa-1"]}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", code, body)
+	}
+
+	// Resolutions serve the endpoints in turn as the address
+	var got []string
+	for i := 0; i < 2; i++ {
+		_, body := call(r, http.MethodGet, "/services/opa", "")
+		var service Service
+		if err := json.Unmarshal([]byte(body), &service); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, service.ServiceAddress)
+	}
+	if got[0] == got[1] || !strings.HasPrefix(got[0], "http://opa-") || !strings.HasPrefix(got[1], "http://opa-") {
+		t.Fatalf("served %q", got)
+	}
+}
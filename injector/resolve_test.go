@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"injectorsdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingStore counts Get calls and holds what they read until release is
+// closed
+type countingStore struct {
+	*memoryStore
+	gets    atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *countingStore) Get(ctx context.Context, id string) (Service, error) {
+	service, err := s.memoryStore.Get(ctx, id)
+	if s.gets.Add(1) == 1 {
+		close(s.started)
+	}
+	<-s.release
+	return service, err
+}
+
+func TestConcurrentMissesShareOneStoreRead(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mem := newMemoryStore()
+	_, err := mem.Put(context.Background(), Service{ID: "hello", ServiceName: "hello", ServiceAddress: "http://hello"}, PutCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingStore{memoryStore: mem, started: make(chan struct{}), release: make(chan struct{})}
+	store = counting
+	cache = injectorsdk.NewCache[Service](time.Minute, time.Second, 100)
+
+	r := gin.New()
+	r.GET("/services/:id", getServiceHandler)
+
+	const n = 50
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/services/hello", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+
+	// Hold the first lookup open long enough for every request to queue behind it
+	<-counting.started
+	time.Sleep(100 * time.Millisecond)
+	close(counting.release)
+	wg.Wait()
+
+	if got := counting.gets.Load(); got != 1 {
+		t.Fatalf("store read %d times, want 1", got)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d returned %d, want 200", i, code)
+		}
+	}
+}
+
+func TestLookupRacingWriteNotCached(t *testing.T) {
+	mem := newMemoryStore()
+	hello := Service{ID: "hello", ServiceName: "hello", ServiceAddress: "http://hello"}
+	if _, err := mem.Put(context.Background(), hello, PutCreate); err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingStore{memoryStore: mem, started: make(chan struct{}), release: make(chan struct{})}
+	store = counting
+	cache = injectorsdk.NewCache[Service](time.Minute, time.Second, 100)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resolve("hello")
+	}()
+
+	// The write lands while the lookup holds the old descriptor
+	<-counting.started
+	hello.ServiceAddress = "http://hello-2"
+	if _, err := mem.Put(context.Background(), hello, PutUpdate); err != nil {
+		t.Fatal(err)
+	}
+	invalidate("hello")
+	close(counting.release)
+	<-done
+
+	if service, _, ok := cache.Get("hello"); ok {
+		t.Fatalf("cached %s read before the write", service.ServiceAddress)
+	}
+	service, err := resolve("hello")
+	if err != nil || service.ServiceAddress != "http://hello-2" {
+		t.Fatalf("got %+v, %v, want the written descriptor", service, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Attributes may hold secret references (secret:file:<path>,
+// secret:env:<VAR> or secret:k8s:<secret>/<key>) that the SDK resolves in the
+// function, so the injector itself never handles the secret values
+var secretRefPattern = regexp.MustCompile(`^secret:(file:.+|env:.+|k8s:[^/]+/.+)$`)
+
+// sensitiveNames are parts of attribute names whose plaintext values are
+// redacted from listings
+var sensitiveNames = []string{"password", "secret", "token", "credential", "key"}
+
+const redacted = "[REDACTED]"
+
+func validateSecretRefs(s Service) error {
+	for k, v := range s.Attributes {
+		if str, ok := v.(string); ok && strings.HasPrefix(str, "secret:") && !secretRefPattern.MatchString(str) {
+			return fmt.Errorf("attribute %s holds an invalid secret reference", k)
+		}
+	}
+	return nil
+}
+
+// redact hides the values of attributes that look like plaintext
+// credentials. Secret references are kept, they are not secret themselves.
+func redact(s Service) Service {
+	s = s.clone()
+	for k, v := range s.Attributes {
+		str, ok := v.(string)
+		if !ok || secretRefPattern.MatchString(str) || !isSensitive(k) {
+			continue
+		}
+		s.Attributes[k] = redacted
+	}
+	return s
+}
+
+func redactAll(services []Service) []Service {
+	for i, s := range services {
+		services[i] = redact(s)
+	}
+	return services
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveNames {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
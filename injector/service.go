@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Service is a registry descriptor. Besides the fixed fields it carries any
+// number of binding specific attributes (e.g. Admin, Password and Bucket for
+// MinIO). Attributes are stored inline in the Mongo document and flattened
+// into the JSON object, so a descriptor round-trips between the two unchanged.
+type Service struct {
+	ID             string `json:"id" bson:"id"`
+	ServiceName    string `json:"ServiceName" bson:"ServiceName"`
+	ServiceAddress string `json:"ServiceAddress" bson:"ServiceAddress"`
+	Kind           string `json:"Kind,omitempty" bson:"Kind,omitempty"`
+	// Revision is assigned by the store and grows by one on every write
+	Revision int64 `json:"Revision,omitempty" bson:"Revision,omitempty"`
+	// Endpoints are replicas of the service. Each resolution serves one of
+	// them as ServiceAddress, chosen according to Strategy.
+	Endpoints []string `json:"Endpoints,omitempty" bson:"Endpoints,omitempty"`
+	Strategy  string   `json:"Strategy,omitempty" bson:"Strategy,omitempty"`
+	// Variants split the traffic between several backends by weight
+	Variants []Variant `json:"Variants,omitempty" bson:"Variants,omitempty"`
+	// Variant names the variant picked for a single resolution, it is
+	// never stored
+	Variant string `json:"Variant,omitempty" bson:"-"`
+	// Expires is when credentials minted for a single resolution
+	// stop working, it is never stored
+	Expires    *time.Time             `json:"Expires,omitempty" bson:"-"`
+	Attributes map[string]interface{} `json:"-" bson:",inline"`
+}
+
+// reservedKeys are the JSON keys owned by the fixed descriptor fields
+var reservedKeys = map[string]bool{
+	"id":             true,
+	"ServiceName":    true,
+	"ServiceAddress": true,
+	"Kind":           true,
+	"Revision":       true,
+	"Endpoints":      true,
+	"Strategy":       true,
+	"Variants":       true,
+	"Variant":        true,
+	"Expires":        true,
+	"_id":            true,
+}
+
+// clone returns a copy whose attribute map can be modified independently
+func (s Service) clone() Service {
+	if s.Attributes != nil {
+		attrs := make(map[string]interface{}, len(s.Attributes))
+		for k, v := range s.Attributes {
+			attrs[k] = v
+		}
+		s.Attributes = attrs
+	}
+	s.Endpoints = append([]string(nil), s.Endpoints...)
+	s.Variants = append([]Variant(nil), s.Variants...)
+	return s
+}
+
+func (s Service) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]interface{}, len(s.Attributes)+4)
+	for k, v := range s.Attributes {
+		obj[k] = v
+	}
+	obj["id"] = s.ID
+	obj["ServiceName"] = s.ServiceName
+	obj["ServiceAddress"] = s.ServiceAddress
+	if s.Kind != "" {
+		obj["Kind"] = s.Kind
+	}
+	if s.Revision != 0 {
+		obj["Revision"] = s.Revision
+	}
+	if len(s.Endpoints) > 0 {
+		obj["Endpoints"] = s.Endpoints
+	}
+	if s.Strategy != "" {
+		obj["Strategy"] = s.Strategy
+	}
+	if len(s.Variants) > 0 {
+		obj["Variants"] = s.Variants
+	}
+	if s.Variant != "" {
+		obj["Variant"] = s.Variant
+	}
+	if s.Expires != nil {
+		obj["Expires"] = s.Expires.Format(time.RFC3339)
+	}
+	return json.Marshal(obj)
+}
+
+func (s *Service) UnmarshalJSON(data []byte) error {
+	obj, err := decodeJSONObject(data)
+	if err != nil {
+		return err
+	}
+
+	*s = Service{}
+	for k, v := range obj {
+		if !reservedKeys[k] {
+			if s.Attributes == nil {
+				s.Attributes = make(map[string]interface{})
+			}
+			s.Attributes[k] = v
+			continue
+		}
+		if k == "_id" || k == "Variant" || k == "Expires" {
+			continue
+		}
+		if k == "Variants" {
+			if s.Variants, err = decodeVariants(v); err != nil {
+				return err
+			}
+			continue
+		}
+		if k == "Endpoints" {
+			if s.Endpoints, err = decodeEndpoints(v); err != nil {
+				return err
+			}
+			continue
+		}
+		if k == "Revision" {
+			rev, ok := v.(int64)
+			if !ok && v != nil {
+				return fmt.Errorf("Revision must be an integer")
+			}
+			s.Revision = rev
+			continue
+		}
+		str, ok := v.(string)
+		if !ok && v != nil {
+			return fmt.Errorf("%s must be a string", k)
+		}
+		switch k {
+		case "id":
+			s.ID = str
+		case "ServiceName":
+			s.ServiceName = str
+		case "ServiceAddress":
+			s.ServiceAddress = str
+		case "Kind":
+			s.Kind = str
+		case "Strategy":
+			s.Strategy = str
+		}
+	}
+	return nil
+}
+
+// decodeJSONObject parses a JSON object keeping numbers typed: integers
+// become int64 and everything else float64, so they are stored in Mongo
+// with the type the client meant
+func decodeJSONObject(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	for k, v := range obj {
+		obj[k] = normalizeJSONValue(v)
+	}
+	return obj, nil
+}
+
+func normalizeJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(val.String(), ".eE") {
+			if i, err := val.Int64(); err == nil {
+				return i
+			}
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeJSONValue(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeJSONValue(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
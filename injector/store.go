@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	ErrNotFound = errors.New("service not found")
+	ErrExists   = errors.New("service already exists")
+	ErrConflict = errors.New("service was modified concurrently")
+)
+
+// PutMode states the precondition a Put must satisfy
+type PutMode int
+
+const (
+	PutUpsert PutMode = iota // create or replace
+	PutCreate                // fail with ErrExists if the id is taken
+	PutUpdate                // fail with ErrNotFound if the id is missing
+)
+
+type EventType string
+
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+	// EventReset tells watchers that changes may have been missed and any
+	// state derived from the store should be dropped
+	EventReset EventType = "reset"
+)
+
+// Event describes a change to a single service in the store. For deletes
+// Revision is the last revision the service had.
+type Event struct {
+	Type     EventType
+	ID       string
+	Revision int64
+	Service  *Service
+}
+
+// Store is the persistence layer behind the injector API
+type Store interface {
+	Get(ctx context.Context, id string) (Service, error)
+	// Put stores service and returns it with its newly assigned Revision
+	Put(ctx context.Context, service Service, mode PutMode) (Service, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Service, error)
+	// History returns every revision ever stored for id, oldest first, also
+	// after the service was deleted. Revisions are never reused.
+	History(ctx context.Context, id string) ([]Service, error)
+	// GetRevision returns id as it was at the given revision
+	GetRevision(ctx context.Context, id string, revision int64) (Service, error)
+	// Watch streams changes until ctx is cancelled, then closes the channel
+	Watch(ctx context.Context) (<-chan Event, error)
+}
+
+// newStoreFromEnv builds the backend selected by STORE_BACKEND (mongo, memory or file)
+func newStoreFromEnv(ctx context.Context) (Store, error) {
+	backend := os.Getenv("STORE_BACKEND")
+	if backend == "" {
+		backend = "mongo"
+	}
+
+	switch backend {
+	case "mongo":
+		mongoURI := os.Getenv("MONGO_URI")
+		if mongoURI == "" {
+			mongoURI = "mongodb://mongo:27017"
+		}
+		return newMongoStore(ctx, mongoURI)
+	case "memory":
+		return newMemoryStore(), nil
+	case "file":
+		path := os.Getenv("STORE_FILE")
+		if path == "" {
+			path = "services.json"
+		}
+		return newFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
+
+// broadcaster fans store events out to the channels returned by Watch
+type broadcaster struct {
+	mu       sync.Mutex
+	watchers map[chan Event]struct{}
+}
+
+func (b *broadcaster) subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	if b.watchers == nil {
+		b.watchers = make(map[chan Event]struct{})
+	}
+	b.watchers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.watchers, ch)
+		b.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (b *broadcaster) publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.watchers {
+		select {
+		case ch <- ev:
+		default:
+			// Slow watcher: drop the event but make sure it resyncs
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- Event{Type: EventReset}:
+			default:
+			}
+		}
+	}
+}
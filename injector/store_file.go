@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileStore is a memoryStore persisted as JSON to a single file. Every
+// write rewrites the whole file, which is fine for registry-sized data.
+type fileStore struct {
+	*memoryStore
+	path string
+}
+
+// storeFile is the file layout. Files written before revision history was
+// kept hold just the array of services.
+type storeFile struct {
+	Services []Service `json:"services"`
+	History  []Service `json:"history"`
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	f := &fileStore{memoryStore: newMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store file: %w", err)
+	}
+
+	var contents storeFile
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+	case data[0] == '[':
+		err = json.Unmarshal(data, &contents.Services)
+		contents.History = contents.Services
+	default:
+		err = json.Unmarshal(data, &contents)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse store file %s: %w", path, err)
+	}
+
+	for _, service := range contents.Services {
+		f.services[service.ID] = service
+	}
+	for _, rev := range contents.History {
+		f.history[rev.ID] = append(f.history[rev.ID], rev)
+	}
+	return f, nil
+}
+
+func (f *fileStore) Put(ctx context.Context, service Service, mode PutMode) (Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev, existed := f.services[service.ID]
+	revs, hadHistory := f.history[service.ID]
+	service, err := f.put(service, mode)
+	if err != nil {
+		return Service{}, err
+	}
+	if err := f.save(); err != nil {
+		if existed {
+			f.services[service.ID] = prev
+		} else {
+			delete(f.services, service.ID)
+		}
+		if hadHistory {
+			f.history[service.ID] = revs
+		} else {
+			delete(f.history, service.ID)
+		}
+		return Service{}, err
+	}
+	f.events.publish(Event{Type: EventPut, ID: service.ID, Revision: service.Revision, Service: &service})
+	return service, nil
+}
+
+func (f *fileStore) Delete(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev, ok := f.services[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(f.services, id)
+	if err := f.save(); err != nil {
+		f.services[id] = prev
+		return err
+	}
+	f.events.publish(Event{Type: EventDelete, ID: id, Revision: prev.Revision})
+	return nil
+}
+
+// save writes the current contents to a temp file and renames it into place,
+// so a crash never leaves a half-written store behind. Callers hold f.mu.
+func (f *fileStore) save() error {
+	var contents storeFile
+	for _, service := range f.services {
+		contents.Services = append(contents.Services, service)
+	}
+	for _, revs := range f.history {
+		contents.History = append(contents.History, revs...)
+	}
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps services in process memory, for standalone runs and tests
+type memoryStore struct {
+	mu       sync.RWMutex
+	services map[string]Service
+	history  map[string][]Service // every revision per id, oldest first
+	events   broadcaster
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{services: make(map[string]Service), history: make(map[string][]Service)}
+}
+
+func (m *memoryStore) Get(ctx context.Context, id string) (Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	service, ok := m.services[id]
+	if !ok {
+		return Service{}, ErrNotFound
+	}
+	return service.clone(), nil
+}
+
+func (m *memoryStore) Put(ctx context.Context, service Service, mode PutMode) (Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	service, err := m.put(service, mode)
+	if err != nil {
+		return Service{}, err
+	}
+	m.events.publish(Event{Type: EventPut, ID: service.ID, Revision: service.Revision, Service: &service})
+	return service, nil
+}
+
+func (m *memoryStore) put(service Service, mode PutMode) (Service, error) {
+	prev, exists := m.services[service.ID]
+	if mode == PutCreate && exists {
+		return Service{}, ErrExists
+	}
+	if mode == PutUpdate && !exists {
+		return Service{}, ErrNotFound
+	}
+	// Continue after the last revision ever stored, so a service that was
+	// deleted and registered again does not reuse old revision numbers
+	service.Revision = prev.Revision + 1
+	if revs := m.history[service.ID]; len(revs) > 0 && revs[len(revs)-1].Revision >= service.Revision {
+		service.Revision = revs[len(revs)-1].Revision + 1
+	}
+	m.services[service.ID] = service.clone()
+	m.history[service.ID] = append(m.history[service.ID], service.clone())
+	return service, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, ok := m.services[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.services, id)
+	m.events.publish(Event{Type: EventDelete, ID: id, Revision: prev.Revision})
+	return nil
+}
+
+func (m *memoryStore) List(ctx context.Context) ([]Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	services := make([]Service, 0, len(m.services))
+	for _, service := range m.services {
+		services = append(services, service.clone())
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+	return services, nil
+}
+
+func (m *memoryStore) History(ctx context.Context, id string) ([]Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revs, ok := m.history[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	history := make([]Service, len(revs))
+	for i, rev := range revs {
+		history[i] = rev.clone()
+	}
+	return history, nil
+}
+
+func (m *memoryStore) GetRevision(ctx context.Context, id string, revision int64) (Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rev := range m.history[id] {
+		if rev.Revision == revision {
+			return rev.clone(), nil
+		}
+	}
+	return Service{}, ErrNotFound
+}
+
+func (m *memoryStore) Watch(ctx context.Context) (<-chan Event, error) {
+	return m.events.subscribe(ctx), nil
+}
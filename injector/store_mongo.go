@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore keeps services in the services.services collection and every
+// revision written through it in services.service_revisions
+type mongoStore struct {
+	collection *mongo.Collection
+	revisions  *mongo.Collection
+}
+
+// withoutObjectID keeps Mongo's _id out of the inline descriptor attributes
+var withoutObjectID = bson.D{{Key: "_id", Value: 0}}
+
+func newMongoStore(ctx context.Context, uri string) (*mongoStore, error) {
+	// Decode nested documents as maps so descriptor attributes encode to
+	// plain JSON objects
+	clientOpts := options.Client().ApplyURI(uri).SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB connection error: %w", err)
+	}
+	collection := client.Database("services").Collection("services")
+
+	// Enforce unique ids so concurrent registrations cannot create duplicates
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Infof("Failed to create index on services.id: %v", err)
+	}
+
+	// Revisions are immutable, so each (id, Revision) pair is stored once
+	revisions := client.Database("services").Collection("service_revisions")
+	_, err = revisions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "Revision", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		logger.Infof("Failed to create index on service_revisions: %v", err)
+	}
+
+	return &mongoStore{collection: collection, revisions: revisions}, nil
+}
+
+func (m *mongoStore) Get(ctx context.Context, id string) (Service, error) {
+	var service Service
+	opts := options.FindOne().SetProjection(withoutObjectID)
+	err := m.collection.FindOne(ctx, bson.D{{Key: "id", Value: id}}, opts).Decode(&service)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Service{}, ErrNotFound
+	}
+	return service, err
+}
+
+func (m *mongoStore) Put(ctx context.Context, service Service, mode PutMode) (Service, error) {
+	service, err := m.put(ctx, service, mode)
+	if err != nil {
+		return Service{}, err
+	}
+	m.record(ctx, service)
+	return service, nil
+}
+
+func (m *mongoStore) put(ctx context.Context, service Service, mode PutMode) (Service, error) {
+	if mode == PutCreate {
+		return m.insert(ctx, service)
+	}
+
+	// Bump the revision optimistically: the replace only matches if nobody
+	// else wrote the document since we read its revision
+	for attempt := 0; attempt < 5; attempt++ {
+		var current struct {
+			Revision int64 `bson:"Revision"`
+		}
+		opts := options.FindOne().SetProjection(bson.D{{Key: "Revision", Value: 1}})
+		err := m.collection.FindOne(ctx, bson.D{{Key: "id", Value: service.ID}}, opts).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if mode == PutUpdate {
+				return Service{}, ErrNotFound
+			}
+			stored, err := m.insert(ctx, service)
+			if errors.Is(err, ErrExists) {
+				continue
+			}
+			return stored, err
+		}
+		if err != nil {
+			return Service{}, err
+		}
+
+		service.Revision = current.Revision + 1
+		res, err := m.collection.ReplaceOne(ctx, revisionFilter(service.ID, current.Revision), service)
+		if err != nil {
+			return Service{}, err
+		}
+		if res.MatchedCount == 1 {
+			return service, nil
+		}
+	}
+	return Service{}, ErrConflict
+}
+
+func (m *mongoStore) insert(ctx context.Context, service Service) (Service, error) {
+	// Continue after the revisions of an earlier service with this id
+	last, err := m.lastRevision(ctx, service.ID)
+	if err != nil {
+		return Service{}, err
+	}
+	service.Revision = last + 1
+	_, err = m.collection.InsertOne(ctx, service)
+	if mongo.IsDuplicateKeyError(err) {
+		return Service{}, ErrExists
+	}
+	if err != nil {
+		return Service{}, err
+	}
+	return service, nil
+}
+
+// record adds service to the revision history. The write itself already
+// succeeded, so a failure here only leaves a gap in the history.
+func (m *mongoStore) record(ctx context.Context, service Service) {
+	if _, err := m.revisions.InsertOne(ctx, service); err != nil {
+		logger.Infof("Failed to record revision %d of '%s': %v", service.Revision, service.ID, err)
+	}
+}
+
+func (m *mongoStore) lastRevision(ctx context.Context, id string) (int64, error) {
+	var last struct {
+		Revision int64 `bson:"Revision"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "Revision", Value: -1}}).SetProjection(bson.D{{Key: "Revision", Value: 1}})
+	err := m.revisions.FindOne(ctx, bson.D{{Key: "id", Value: id}}, opts).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return last.Revision, err
+}
+
+// revisionFilter matches id at exactly revision, where revision 0 stands for
+// documents written before revisions existed
+func revisionFilter(id string, revision int64) bson.D {
+	if revision == 0 {
+		return bson.D{{Key: "id", Value: id}, {Key: "Revision", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}}
+	}
+	return bson.D{{Key: "id", Value: id}, {Key: "Revision", Value: revision}}
+}
+
+func (m *mongoStore) Delete(ctx context.Context, id string) error {
+	res, err := m.collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoStore) List(ctx context.Context) ([]Service, error) {
+	cursor, err := m.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetProjection(withoutObjectID))
+	if err != nil {
+		return nil, err
+	}
+
+	services := []Service{}
+	if err := cursor.All(ctx, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (m *mongoStore) History(ctx context.Context, id string) ([]Service, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "Revision", Value: 1}}).SetProjection(withoutObjectID)
+	cursor, err := m.revisions.Find(ctx, bson.D{{Key: "id", Value: id}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var history []Service
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+
+	// Services last written before history was kept only have their
+	// current revision
+	current, err := m.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		if len(history) == 0 {
+			return nil, ErrNotFound
+		}
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || history[len(history)-1].Revision < current.Revision {
+		history = append(history, current)
+	}
+	return history, nil
+}
+
+func (m *mongoStore) GetRevision(ctx context.Context, id string, revision int64) (Service, error) {
+	var service Service
+	opts := options.FindOne().SetProjection(withoutObjectID)
+	err := m.revisions.FindOne(ctx, bson.D{{Key: "id", Value: id}, {Key: "Revision", Value: revision}}, opts).Decode(&service)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Possibly a revision written before history was kept
+		current, err := m.Get(ctx, id)
+		if err == nil && current.Revision == revision {
+			return current, nil
+		}
+		return Service{}, ErrNotFound
+	}
+	return service, err
+}
+
+// Watch follows the collection's change stream. Change streams need a replica
+// set, so on a standalone mongod this returns the server's error.
+func (m *mongoStore) Watch(ctx context.Context) (<-chan Event, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := m.collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete events only carry the document _id, so remember which service
+	// each _id belongs to
+	ids, err := m.objectIDs(ctx)
+	if err != nil {
+		stream.Close(context.Background())
+		return nil, err
+	}
+
+	ch := make(chan Event, 64)
+	go func() {
+		defer close(ch)
+		defer stream.Close(context.Background())
+
+		for stream.Next(ctx) {
+			ev, ok := decodeChange(stream, ids)
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			logger.Infof("MongoDB change stream closed: %v", err)
+		}
+	}()
+
+	return ch, nil
+}
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ObjectID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *Service `bson:"fullDocument"`
+}
+
+// objectIDIndex maps Mongo _id values to the service id and last revision
+type objectIDIndex map[interface{}]indexedService
+
+type indexedService struct {
+	id       string
+	revision int64
+}
+
+func (m *mongoStore) objectIDs(ctx context.Context) (objectIDIndex, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "id", Value: 1}, {Key: "Revision", Value: 1}})
+	cursor, err := m.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ObjectID interface{} `bson:"_id"`
+		ID       string      `bson:"id"`
+		Revision int64       `bson:"Revision"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	index := make(objectIDIndex, len(docs))
+	for _, doc := range docs {
+		index[doc.ObjectID] = indexedService{id: doc.ID, revision: doc.Revision}
+	}
+	return index, nil
+}
+
+func decodeChange(stream *mongo.ChangeStream, index objectIDIndex) (Event, bool) {
+	var change changeEvent
+	if err := stream.Decode(&change); err != nil {
+		logger.Infof("Failed to decode change event: %v", err)
+		return Event{Type: EventReset}, true
+	}
+
+	switch change.OperationType {
+	case "insert", "update", "replace":
+		if change.FullDocument == nil {
+			// The document was deleted again before the lookup ran
+			return Event{}, false
+		}
+		service := change.FullDocument
+		delete(service.Attributes, "_id")
+		prev, ok := index[change.DocumentKey.ObjectID]
+		index[change.DocumentKey.ObjectID] = indexedService{id: service.ID, revision: service.Revision}
+		if ok && prev.id != service.ID {
+			// The id field itself was rewritten, the old id is gone as well
+			return Event{Type: EventReset}, true
+		}
+		return Event{Type: EventPut, ID: service.ID, Revision: service.Revision, Service: service}, true
+	case "delete":
+		prev, ok := index[change.DocumentKey.ObjectID]
+		if !ok {
+			return Event{Type: EventReset}, true
+		}
+		delete(index, change.DocumentKey.ObjectID)
+		return Event{Type: EventDelete, ID: prev.id, Revision: prev.revision}, true
+	case "drop", "rename", "dropDatabase", "invalidate":
+		return Event{Type: EventReset}, true
+	default:
+		return Event{}, false
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryStorePreconditions(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	hello := Service{ID: "hello", ServiceName: "hello", ServiceAddress: "http://hello"}
+
+	if _, err := s.Put(ctx, hello, PutUpdate); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update of a missing service: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Put(ctx, hello, PutCreate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, hello, PutCreate); !errors.Is(err, ErrExists) {
+		t.Fatalf("second create: got %v, want ErrExists", err)
+	}
+	if _, err := s.Put(ctx, hello, PutUpsert); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "hello"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "hello"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "services.json")
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minio := Service{ID: "minio", ServiceName: "minio", ServiceAddress: "http://minio:9000", Kind: "minio",
+		Attributes: map[string]interface{}{"Bucket": "data"}}
+	for _, service := range []Service{minio, {ID: "gone", ServiceName: "gone", ServiceAddress: "http://gone"}} {
+		if _, err := s.Put(ctx, service, PutCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	minio.ServiceAddress = "http://minio:9001"
+	if _, err := s.Put(ctx, minio, PutUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get(ctx, "minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ServiceAddress != "http://minio:9001" || got.Kind != "minio" || got.Revision != 2 || got.Attributes["Bucket"] != "data" {
+		t.Fatalf("reloaded %+v", got)
+	}
+	if _, err := reopened.Get(ctx, "gone"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted service reloaded: %v", err)
+	}
+	if history, err := reopened.History(ctx, "gone"); err != nil || len(history) != 1 {
+		t.Fatalf("history of the deleted service: %v, %v", history, err)
+	}
+}
+
+func TestFileStoreLegacyArray(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "services.json")
+	legacy := `[{"id":"hello","ServiceName":"hello","ServiceAddress":"http://hello","Region":"eu"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ServiceAddress != "http://hello" || got.Attributes["Region"] != "eu" {
+		t.Fatalf("loaded %+v", got)
+	}
+
+	// The next write converts the file to the current layout
+	got.ServiceAddress = "http://hello-2"
+	if _, err := s.Put(ctx, got, PutUpdate); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history, err := reopened.History(ctx, "hello"); err != nil || len(history) != 2 {
+		t.Fatalf("history after converting: %v, %v", history, err)
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "services.json")
+	stores := map[string]func() (Store, error){
+		"memory": func() (Store, error) { return newMemoryStore(), nil },
+		"file":   func() (Store, error) { return newFileStore(path) },
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			put := func(address string, mode PutMode, want int64) {
+				t.Helper()
+				service, err := s.Put(ctx, Service{ID: "hello", ServiceName: "hello", ServiceAddress: address}, mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if service.Revision != want {
+					t.Fatalf("stored %s as revision %d, want %d", address, service.Revision, want)
+				}
+			}
+
+			put("http://hello-1", PutCreate, 1)
+			put("http://hello-2", PutUpdate, 2)
+			if err := s.Delete(ctx, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			// Registering it again continues after the deleted revisions
+			put("http://hello-3", PutCreate, 3)
+
+			if name == "file" {
+				if s, err = open(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			put("http://hello-4", PutUpsert, 4)
+
+			history, err := s.History(ctx, "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 4 {
+				t.Fatalf("%d revisions in the history, want 4", len(history))
+			}
+			for i, rev := range history {
+				if rev.Revision != int64(i+1) {
+					t.Fatalf("revision %d listed at position %d", rev.Revision, i)
+				}
+			}
+
+			old, err := s.GetRevision(ctx, "hello", 2)
+			if err != nil || old.ServiceAddress != "http://hello-2" {
+				t.Fatalf("revision 2: got %+v, %v", old, err)
+			}
+			if _, err := s.GetRevision(ctx, "hello", 5); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("future revision: got %v, want ErrNotFound", err)
+			}
+			if _, err := s.History(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("history of an unknown id: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
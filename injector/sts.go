@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+// minioSTSBroker mints credentials with MinIO's AssumeRole STS API. The
+// temporary keys inherit the policies of the broker's own user, narrowed by
+// a session policy to the descriptor's bucket.
+type minioSTSBroker struct {
+	endpoint  string
+	accessKey string
+	secretKey string
+	region    string
+	client    *http.Client
+}
+
+// newMinioSTSBrokerFromEnv configures the broker from MINIO_STS_ENDPOINT,
+// e.g. http://minio.default.svc.cluster.local:9000, MINIO_STS_ACCESS_KEY,
+// MINIO_STS_SECRET_KEY and optionally MINIO_STS_REGION
+func newMinioSTSBrokerFromEnv() (*minioSTSBroker, error) {
+	b := &minioSTSBroker{
+		endpoint:  os.Getenv("MINIO_STS_ENDPOINT"),
+		accessKey: os.Getenv("MINIO_STS_ACCESS_KEY"),
+		secretKey: os.Getenv("MINIO_STS_SECRET_KEY"),
+		region:    os.Getenv("MINIO_STS_REGION"),
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+	if b.endpoint == "" || b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("minio-sts needs MINIO_STS_ENDPOINT, MINIO_STS_ACCESS_KEY and MINIO_STS_SECRET_KEY")
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	return b, nil
+}
+
+func (b *minioSTSBroker) Mint(ctx context.Context, req CredentialRequest) (Credentials, error) {
+	if req.Bucket == "" {
+		// Without a bucket the keys would get all of the broker's rights
+		return Credentials{}, fmt.Errorf("service '%s' has no Bucket to scope credentials to", req.ServiceID)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", credentials.STSVersion)
+	form.Set("DurationSeconds", strconv.Itoa(int(req.TTL.Seconds())))
+	form.Set("Policy", bucketPolicy(req.Bucket))
+	body := form.Encode()
+
+	u, err := url.Parse(b.endpoint)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid MINIO_STS_ENDPOINT: %w", err)
+	}
+	u.Path = "/"
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return Credentials{}, err
+	}
+	sum := sha256.Sum256([]byte(body))
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	httpReq = signer.SignV4STS(*httpReq, b.accessKey, b.secretKey, b.region)
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to call MinIO STS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read MinIO STS response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp credentials.ErrorResponse
+		if xml.Unmarshal(data, &errResp) == nil && errResp.STSError.Code != "" {
+			return Credentials{}, fmt.Errorf("MinIO STS refused AssumeRole: %s: %s", errResp.STSError.Code, errResp.STSError.Message)
+		}
+		return Credentials{}, fmt.Errorf("MinIO STS answered %d", resp.StatusCode)
+	}
+
+	var result credentials.AssumeRoleResponse
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse MinIO STS response: %w", err)
+	}
+	creds := result.Result.Credentials
+	return Credentials{
+		AccessKey:    creds.AccessKey,
+		SecretKey:    creds.SecretKey,
+		SessionToken: creds.SessionToken,
+		Expires:      creds.Expiration,
+	}, nil
+}
+
+// bucketPolicy is the session policy limiting credentials to bucket
+func bucketPolicy(bucket string) string {
+	policy, _ := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":   "Allow",
+			"Action":   []string{"s3:*"},
+			"Resource": []string{"arn:aws:s3:::" + bucket, "arn:aws:s3:::" + bucket + "/*"},
+		}},
+	})
+	return string(policy)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"injectorsdk"
+)
+
+// reloadCheckInterval bounds how often certificate and key files are
+// checked for changes
+var reloadCheckInterval = 10 * time.Second
+
+// mtls is set when client certificates are verified. A verified certificate
+// then identifies the caller, with mtlsScopes, as a bearer token would.
+var (
+	mtls       bool
+	mtlsScopes []string
+)
+
+// serverCerts are the certificate the injector serves and, in mTLS mode, the
+// CAs client certificates are verified against. Both are re-read when their
+// files change, so rotated certificates need no restart.
+type serverCerts struct {
+	cert *injectorsdk.Reloader[*tls.Certificate]
+	cas  *injectorsdk.Reloader[*x509.CertPool]
+}
+
+// newServerCertsFromEnv loads TLS_CERT_FILE and TLS_KEY_FILE, or returns nil
+// to serve plain HTTP. With TLS_CLIENT_CA_FILE client certificates issued by
+// that CA are verified and identify their caller, with the scopes in
+// TLS_CLIENT_SCOPES (services:read if unset).
+func newServerCertsFromEnv() (*serverCerts, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("set both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	var certs serverCerts
+	var err error
+	certs.cert, err = injectorsdk.NewReloader(reloadCheckInterval, func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			// A rotation may have replaced only one of the files so far
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		logger.Infof("Loaded TLS certificate from %s", certFile)
+		return &cert, nil
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if caFile == "" {
+		return &certs, nil
+	}
+
+	certs.cas, err = injectorsdk.NewReloader(reloadCheckInterval, func() (*x509.CertPool, error) {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		cas := x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in client CA %s", caFile)
+		}
+		logger.Infof("Loaded client CA from %s", caFile)
+		return cas, nil
+	}, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	mtls = true
+	mtlsScopes = []string{ScopeRead}
+	if scopes := os.Getenv("TLS_CLIENT_SCOPES"); scopes != "" {
+		mtlsScopes = strings.Fields(scopes)
+	}
+	return &certs, nil
+}
+
+// config returns the TLS configuration of a server speaking protos. Every
+// server needs its own, http.Server and gRPC both change the one they get.
+func (s *serverCerts) config(protos ...string) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: protos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := s.cert.Get()
+			if err != nil {
+				// Keep serving the certificate we have
+				logger.Infof("%v", err)
+			}
+			return cert, nil
+		},
+	}
+	if s.cas == nil {
+		return config
+	}
+
+	// Certificates are verified if given rather than required, so kubelet
+	// probes and token authenticated callers still get through
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cas, err := s.cas.Get()
+		if err != nil {
+			logger.Infof("%v", err)
+		}
+		// The clone keeps protos, which the server only set on its own copy
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cas
+		return c, nil
+	}
+	return config
+}
+
+// certIdentity returns the identity in the verified client certificate of
+// a connection, taken from its SAN: a URI, then a DNS name, then an email
+// address. SPIFFE ids of Kubernetes service accounts,
+// spiffe://<trust domain>/ns/<namespace>/sa/<name>, become "<namespace>/<name>"
+// like the subjects of service account tokens, so one set of authorization
+// rules covers both.
+func certIdentity(state *tls.ConnectionState) (Identity, bool) {
+	if !mtls || state == nil || len(state.VerifiedChains) == 0 {
+		return Identity{}, false
+	}
+	leaf := state.VerifiedChains[0][0]
+
+	var subject string
+	switch {
+	case len(leaf.URIs) > 0:
+		uri := leaf.URIs[0]
+		subject = uri.String()
+		if parts := strings.Split(strings.Trim(uri.Path, "/"), "/"); uri.Scheme == "spiffe" && len(parts) == 4 && parts[0] == "ns" && parts[2] == "sa" {
+			subject = parts[1] + "/" + parts[3]
+		}
+	case len(leaf.DNSNames) > 0:
+		subject = leaf.DNSNames[0]
+	case len(leaf.EmailAddresses) > 0:
+		subject = leaf.EmailAddresses[0]
+	default:
+		return Identity{}, false
+	}
+	return Identity{Subject: subject, Scopes: mtlsScopes}, true
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupCerts creates a CA in a temporary directory, issues the injector a
+// certificate for localhost and points TLS_CERT_FILE and TLS_KEY_FILE at it.
+// Files are checked for changes on every use, and the mTLS globals are
+// restored after the test.
+func setupCerts(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := caInit([]string{"-dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	issueCert(t, dir, "injector", "-dns", "localhost", "-ip", "127.0.0.1")
+	t.Setenv("TLS_CERT_FILE", filepath.Join(dir, "injector.crt"))
+	t.Setenv("TLS_KEY_FILE", filepath.Join(dir, "injector.key"))
+	t.Setenv("TLS_CLIENT_CA_FILE", "")
+
+	interval, oldMTLS, oldScopes := reloadCheckInterval, mtls, mtlsScopes
+	reloadCheckInterval = 0
+	t.Cleanup(func() { reloadCheckInterval, mtls, mtlsScopes = interval, oldMTLS, oldScopes })
+	return dir
+}
+
+// issueCert issues dir/name.crt, replacing any earlier one, and moves its
+// modification time forward so a reload sees it even on coarse clocks
+func issueCert(t *testing.T, dir, name string, args ...string) {
+	t.Helper()
+	if err := caIssue(append([]string{"-dir", dir, "-name", name, "-force"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	for _, ext := range []string{".crt", ".key"} {
+		if err := os.Chtimes(filepath.Join(dir, name+ext), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServerCertsReload(t *testing.T) {
+	dir := setupCerts(t)
+	certs, err := newServerCertsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtls {
+		t.Fatal("mTLS on without TLS_CLIENT_CA_FILE")
+	}
+	config := certs.config("h2")
+
+	served := func() *x509.Certificate {
+		t.Helper()
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf
+	}
+	before := served()
+
+	issueCert(t, dir, "injector", "-dns", "injector.default.svc")
+	after := served()
+	if after.Equal(before) || len(after.DNSNames) != 1 || after.DNSNames[0] != "injector.default.svc" {
+		t.Fatalf("served %v after the rotation, want the new certificate", after.DNSNames)
+	}
+
+	// A half written rotation keeps the previous certificate in service
+	if err := os.WriteFile(filepath.Join(dir, "injector.key"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(2 * time.Minute)
+	os.Chtimes(filepath.Join(dir, "injector.key"), later, later)
+	if !served().Equal(after) {
+		t.Fatal("certificate dropped while its key is being replaced")
+	}
+}
+
+func TestServerCertsConfig(t *testing.T) {
+	dir := setupCerts(t)
+	t.Setenv("TLS_CLIENT_CA_FILE", filepath.Join(dir, "ca.crt"))
+	certs, err := newServerCertsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpcConfig, httpConfig := certs.config("h2"), certs.config("h2", "http/1.1")
+	if grpcConfig == httpConfig {
+		t.Fatal("gRPC and HTTP share a TLS configuration")
+	}
+	c, err := httpConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.NextProtos) != 2 || c.NextProtos[0] != "h2" || c.NextProtos[1] != "http/1.1" {
+		t.Fatalf("per client configuration offers %q, want h2 and http/1.1", c.NextProtos)
+	}
+	if c.ClientCAs == nil || c.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatal("per client configuration does not verify client certificates")
+	}
+}
+
+func TestCertIdentity(t *testing.T) {
+	defer func(on bool, scopes []string) { mtls, mtlsScopes = on, scopes }(mtls, mtlsScopes)
+	mtls, mtlsScopes = true, []string{ScopeRead}
+
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	tests := []struct {
+		name string
+		leaf *x509.Certificate
+		want string
+	}{
+		{"service account", &x509.Certificate{URIs: []*url.URL{parse("spiffe://cluster.local/ns/default/sa/caller-minio")}}, "default/caller-minio"},
+		{"other SPIFFE id", &x509.Certificate{URIs: []*url.URL{parse("spiffe://cluster.local/workload/caller")}}, "spiffe://cluster.local/workload/caller"},
+		{"URI before DNS", &x509.Certificate{URIs: []*url.URL{parse("spiffe://cluster.local/ns/apps/sa/web")}, DNSNames: []string{"web"}}, "apps/web"},
+		{"DNS name", &x509.Certificate{DNSNames: []string{"caller.default.svc", "caller"}}, "caller.default.svc"},
+		{"email", &x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, "ops@example.com"},
+		{"no SAN", &x509.Certificate{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := certIdentity(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.leaf}}})
+			if ok != (tt.want != "") || id.Subject != tt.want {
+				t.Fatalf("got %q, %v, want %q", id.Subject, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := certIdentity(&tls.ConnectionState{}); ok {
+		t.Fatal("identity without a verified chain")
+	}
+	mtls = false
+	if _, ok := certIdentity(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tests[0].leaf}}}); ok {
+		t.Fatal("identity with mTLS off")
+	}
+}
+
+func TestMTLSCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(a *Authenticator) { auth = a }(auth)
+	auth = nil
+
+	dir := setupCerts(t)
+	t.Setenv("TLS_CLIENT_CA_FILE", filepath.Join(dir, "ca.crt"))
+	certs, err := newServerCertsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issueCert(t, dir, "caller", "-uri", "spiffe://cluster.local/ns/default/sa/caller-minio")
+
+	r := gin.New()
+	r.GET("/whoami", requireScope(ScopeRead), func(c *gin.Context) { c.String(http.StatusOK, callerID(c)) })
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: r, TLSConfig: certs.config("h2", "http/1.1")}
+	go srv.ServeTLS(lis, "", "")
+	defer srv.Close()
+
+	caPEM, _ := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	get := func(clientCerts ...tls.Certificate) (*http.Response, string) {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: clientCerts},
+			ForceAttemptHTTP2: true,
+		}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + lis.Addr().String() + "/whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	callerCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "caller.crt"), filepath.Join(dir, "caller.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := get(callerCert)
+	if resp.StatusCode != http.StatusOK || body != "default/caller-minio" {
+		t.Fatalf("got %d %q, want the caller's service account", resp.StatusCode, body)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("negotiated %s, want HTTP/2", resp.Proto)
+	}
+
+	if resp, _ := get(); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %d without a client certificate, want 401", resp.StatusCode)
+	}
+}
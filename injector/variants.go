@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"injectorsdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Variant is one backend of a service whose traffic is split by weight, the
+// same type the SDK picks from in sdk mode
+type Variant = injectorsdk.Variant
+
+// decodeVariants converts the generic JSON value of a Variants key
+func decodeVariants(v interface{}) ([]Variant, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var variants []Variant
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return nil, fmt.Errorf("Variants must be a list of {Name, ServiceAddress, Weight}: %w", err)
+	}
+	return variants, nil
+}
+
+func validateVariants(variants []Variant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	var total int64
+	names := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if strings.TrimSpace(variant.Name) == "" {
+			return errors.New("every variant needs a Name")
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("variant %q is listed twice", variant.Name)
+		}
+		names[variant.Name] = true
+		if strings.TrimSpace(variant.ServiceAddress) == "" {
+			return fmt.Errorf("variant %q needs a ServiceAddress", variant.Name)
+		}
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %q has a negative Weight", variant.Name)
+		}
+		total += variant.Weight
+	}
+	if total == 0 {
+		return errors.New("variant weights must not all be zero")
+	}
+	return nil
+}
+
+// pickVariant returns the descriptor to serve for one resolution of service:
+// with variants, the one injectorsdk.PickVariant chooses for key. Registration
+// rejects bad weights, but descriptors written to Mongo directly are not
+// validated, so without any positive weight the base service is served.
+func pickVariant(service Service, key string) Service {
+	picked, ok := injectorsdk.PickVariant(service.ID, service.Variants, key)
+	if !ok {
+		return service
+	}
+
+	service = service.clone()
+	service.ServiceAddress = picked.ServiceAddress
+	service.Variant = picked.Name
+	service.Variants = nil
+	// The endpoints are replicas of the base service, not of the variant
+	service.Endpoints = nil
+	return service
+}
+
+// routingKey is the caller supplied key for sticky variant selection, from
+// the key query parameter or the X-Routing-Key header
+func routingKey(c *gin.Context) string {
+	if key := c.Query("key"); key != "" {
+		return key
+	}
+	return c.GetHeader("X-Routing-Key")
+}
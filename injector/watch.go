@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// changes relays store events to the SSE watch endpoints
+var changes broadcaster
+
+// watchStore keeps the resolution cache in sync with the store until ctx is
+// cancelled. Backends without change notifications (e.g. a standalone mongod)
+// fall back to polling the store every pollInterval.
+func watchStore(ctx context.Context, s Store, pollInterval time.Duration) {
+	for {
+		events, err := s.Watch(ctx)
+		if err != nil {
+			logger.Infof("Store watch unavailable, polling every %s: %v", pollInterval, err)
+			events = pollStore(ctx, s, pollInterval)
+		}
+
+		for ev := range events {
+			applyEvent(ev)
+			changes.publish(ev)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The stream broke, so changes may have been missed meanwhile
+		logger.Infof("Store watch interrupted, resubscribing")
+		applyEvent(Event{Type: EventReset})
+		changes.publish(Event{Type: EventReset})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// applyEvent evicts or refreshes the cache entry affected by ev
+func applyEvent(ev Event) {
+	switch ev.Type {
+	case EventPut:
+		if ev.Service != nil {
+			refreshCached(ev.ID, *ev.Service)
+		} else {
+			invalidate(ev.ID)
+		}
+	case EventDelete:
+		invalidate(ev.ID)
+		logger.Infof("Cache entry for '%s' evicted", ev.ID)
+	case EventReset:
+		invalidateAll()
+		logger.Infof("Cache cleared")
+	}
+}
+
+// pollStore emulates Watch by diffing successive List results
+func pollStore(ctx context.Context, s Store, interval time.Duration) <-chan Event {
+	ch := make(chan Event, 64)
+
+	go func() {
+		defer close(ch)
+
+		snapshot, err := listByID(ctx, s)
+		if err != nil {
+			logger.Infof("Polling store failed: %v", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := listByID(ctx, s)
+			if err != nil {
+				logger.Infof("Polling store failed: %v", err)
+				continue
+			}
+			if snapshot == nil {
+				// Nothing to diff against, the cache may hold anything
+				snapshot = current
+				if !send(ctx, ch, Event{Type: EventReset}) {
+					return
+				}
+				continue
+			}
+
+			for id, service := range current {
+				if prev, ok := snapshot[id]; !ok || !reflect.DeepEqual(prev, service) {
+					service := service
+					if !send(ctx, ch, Event{Type: EventPut, ID: id, Revision: service.Revision, Service: &service}) {
+						return
+					}
+				}
+			}
+			for id, prev := range snapshot {
+				if _, ok := current[id]; !ok {
+					if !send(ctx, ch, Event{Type: EventDelete, ID: id, Revision: prev.Revision}) {
+						return
+					}
+				}
+			}
+			snapshot = current
+		}
+	}()
+
+	return ch
+}
+
+func listByID(ctx context.Context, s Store) (map[string]Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	services, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Service, len(services))
+	for _, service := range services {
+		byID[service.ID] = service
+	}
+	return byID, nil
+}
+
+func send(ctx context.Context, ch chan<- Event, ev Event) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
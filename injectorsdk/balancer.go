@@ -0,0 +1,177 @@
+package injectorsdk
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Load balancing strategies a descriptor can ask for in Strategy. Round
+// robin is used when it names none.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyRandom           = "random"
+	StrategyLeastOutstanding = "least-outstanding"
+	StrategyLatency          = "latency"
+)
+
+// latencyDecay is the weight of a new observation in an endpoint's moving
+// average call time
+const latencyDecay = 0.3
+
+// unhealthyFor is how long an endpoint marked unhealthy is skipped
+const unhealthyFor = 10 * time.Second
+
+// Balancer spreads calls over the endpoints of a service. Build it once per
+// descriptor, e.g. in the factory of a Container, so its observations of
+// outstanding calls and call times carry across invocations.
+type Balancer struct {
+	strategy string
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+}
+
+type endpoint struct {
+	address     string
+	outstanding int
+	latency     time.Duration // moving average, zero until the first call
+	downUntil   time.Time     // skipped until then, see MarkUnhealthy
+}
+
+// NewBalancer balances over service.Endpoints, or just ServiceAddress if the
+// descriptor lists none
+func NewBalancer(service Service) *Balancer {
+	addresses := service.Endpoints
+	if len(addresses) == 0 {
+		addresses = []string{service.ServiceAddress}
+	}
+
+	b := &Balancer{strategy: service.Strategy}
+	for _, address := range addresses {
+		b.endpoints = append(b.endpoints, &endpoint{address: address})
+	}
+	return b
+}
+
+// Pick chooses the endpoint for one call, skipping those marked unhealthy
+// unless all of them are. done must be called when the call finished, it
+// records the call for the least-outstanding and latency strategies.
+func (b *Balancer) Pick() (address string, done func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	endpoints := b.healthy()
+	var ep *endpoint
+	switch b.strategy {
+	case StrategyRandom:
+		ep = endpoints[rand.Intn(len(endpoints))]
+	case StrategyLeastOutstanding:
+		ep = b.leastOutstanding(endpoints)
+	case StrategyLatency:
+		ep = b.fastest(endpoints)
+	default:
+		ep = endpoints[b.next%len(endpoints)]
+		b.next++
+	}
+
+	ep.outstanding++
+	start := time.Now()
+	var once sync.Once
+	return ep.address, func() {
+		once.Do(func() { b.finish(ep, time.Since(start)) })
+	}
+}
+
+// MarkUnhealthy stops Pick from choosing address for a while, e.g. after a
+// call to it failed to connect
+func (b *Balancer) MarkUnhealthy(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ep := range b.endpoints {
+		if ep.address == address {
+			ep.downUntil = time.Now().Add(unhealthyFor)
+		}
+	}
+}
+
+// healthy returns the endpoints not marked unhealthy, or all of them if
+// every one is, since a call may still succeed. Callers hold b.mu.
+func (b *Balancer) healthy() []*endpoint {
+	now := time.Now()
+	endpoints := make([]*endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if !now.Before(ep.downUntil) {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	if len(endpoints) == 0 {
+		return b.endpoints
+	}
+	return endpoints
+}
+
+func (b *Balancer) finish(ep *endpoint, took time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ep.outstanding--
+	if ep.latency == 0 {
+		ep.latency = took
+	} else {
+		ep.latency = time.Duration(latencyDecay*float64(took) + (1-latencyDecay)*float64(ep.latency))
+	}
+}
+
+// leastOutstanding returns the one of endpoints with the fewest calls in
+// flight, rotating among ties so idle endpoints share the load. Callers
+// hold b.mu.
+func (b *Balancer) leastOutstanding(endpoints []*endpoint) *endpoint {
+	var best *endpoint
+	for i := range endpoints {
+		ep := endpoints[(b.next+i)%len(endpoints)]
+		if best == nil || ep.outstanding < best.outstanding {
+			best = ep
+		}
+	}
+	b.next++
+	return best
+}
+
+// fastest picks one of endpoints at random weighted by the inverse of its
+// average call time. Endpoints not called yet count as fast as the fastest
+// one, so they get tried. Callers hold b.mu.
+func (b *Balancer) fastest(endpoints []*endpoint) *endpoint {
+	var best time.Duration
+	for _, ep := range endpoints {
+		if ep.latency > 0 && (best == 0 || ep.latency < best) {
+			best = ep.latency
+		}
+	}
+	if best == 0 {
+		return endpoints[rand.Intn(len(endpoints))]
+	}
+
+	weights := make([]float64, len(endpoints))
+	var total float64
+	for i, ep := range endpoints {
+		latency := ep.latency
+		if latency == 0 {
+			latency = best
+		}
+		// Calls in flight will take at least as long again
+		weights[i] = 1 / (float64(latency) * float64(1+ep.outstanding))
+		total += weights[i]
+	}
+
+	n := rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return endpoints[i]
+		}
+		n -= w
+	}
+	return endpoints[len(endpoints)-1]
+}
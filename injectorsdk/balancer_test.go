@@ -0,0 +1,80 @@
+package injectorsdk
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := NewBalancer(Service{ServiceAddress: "http://a", Endpoints: []string{"http://a", "http://b", "http://c"}})
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		address, done := b.Pick()
+		done()
+		got = append(got, address)
+	}
+	want := []string{"http://a", "http://b", "http://c", "http://a", "http://b", "http://c"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("picked %q, want %q", got, want)
+	}
+}
+
+func TestBalancerSkipsUnhealthy(t *testing.T) {
+	for _, strategy := range []string{"", StrategyRandom, StrategyLeastOutstanding, StrategyLatency} {
+		t.Run(strategy, func(t *testing.T) {
+			b := NewBalancer(Service{Endpoints: []string{"http://a", "http://b", "http://c"}, Strategy: strategy})
+			b.MarkUnhealthy("http://b")
+			for i := 0; i < 30; i++ {
+				address, done := b.Pick()
+				done()
+				if address == "http://b" {
+					t.Fatal("picked the unhealthy endpoint")
+				}
+			}
+
+			// With every endpoint down the calls still go somewhere
+			b.MarkUnhealthy("http://a")
+			b.MarkUnhealthy("http://c")
+			if address, done := b.Pick(); address == "" {
+				t.Fatal("picked nothing with every endpoint unhealthy")
+			} else {
+				done()
+			}
+		})
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	b := NewBalancer(Service{Endpoints: []string{"http://a", "http://b"}, Strategy: StrategyLeastOutstanding})
+
+	busy, done := b.Pick()
+	for i := 0; i < 5; i++ {
+		address, finish := b.Pick()
+		finish()
+		if address == busy {
+			t.Fatalf("picked %s with a call in flight over an idle endpoint", busy)
+		}
+	}
+	done()
+}
+
+func TestBalancerNoEndpoints(t *testing.T) {
+	// A descriptor without endpoints is balanced over its address alone
+	b := NewBalancer(Service{ServiceAddress: "http://hello"})
+	for i := 0; i < 3; i++ {
+		if address, done := b.Pick(); address != "http://hello" {
+			t.Fatalf("picked %q, want http://hello", address)
+		} else {
+			done()
+		}
+	}
+
+	// and one with neither gives an empty address rather than panicking
+	b = NewBalancer(Service{Endpoints: []string{}})
+	address, done := b.Pick()
+	done()
+	if address != "" {
+		t.Fatalf("picked %q from nothing", address)
+	}
+}
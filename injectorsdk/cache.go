@@ -0,0 +1,178 @@
+package injectorsdk
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is the resolution cache: an LRU bounded to maxEntries whose entries
+// expire after ttl. Lookups of unknown ids are remembered as well, for the
+// shorter negativeTTL, so repeated misses do not all hit the store.
+// A zero ttl or maxEntries disables that limit. V is the descriptor type,
+// Service here; the injector caches its own.
+type Cache[V any] struct {
+	mu          sync.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List // front is most recently used
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type cacheEntry[V any] struct {
+	id       string
+	service  V
+	notFound bool
+	expires  time.Time
+}
+
+// CacheStats is a snapshot of the cache counters
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+func NewCache[V any](ttl, negativeTTL time.Duration, maxEntries int) *Cache[V] {
+	return &Cache[V]{
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+	}
+}
+
+// Get looks id up. ok reports whether the cache had an answer at all and
+// notFound whether that answer is a cached miss.
+func (c *Cache[V]) Get(id string) (service V, notFound bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, found := c.entries[id]
+	if !found {
+		c.misses.Add(1)
+		return service, false, false
+	}
+	entry := el.Value.(*cacheEntry[V])
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(el)
+		c.evictions.Add(1)
+		c.misses.Add(1)
+		return service, false, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return entry.service, entry.notFound, true
+}
+
+func (c *Cache[V]) Set(id string, service V) {
+	c.set(&cacheEntry[V]{id: id, service: service}, c.ttl)
+}
+
+// SetNotFound remembers that id does not exist, if negative caching is enabled
+func (c *Cache[V]) SetNotFound(id string) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	c.set(&cacheEntry[V]{id: id, notFound: true}, c.negativeTTL)
+}
+
+// Refresh replaces the entry for id only if one is cached. It keeps the
+// entry's expiry and LRU position, so refreshes never extend its lifetime.
+func (c *Cache[V]) Refresh(id string, service V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, found := c.entries[id]
+	if !found {
+		return
+	}
+	entry := el.Value.(*cacheEntry[V])
+	if entry.notFound {
+		// A cached miss that now exists gets the positive TTL
+		entry.notFound = false
+		entry.expires = time.Time{}
+		if c.ttl > 0 {
+			entry.expires = time.Now().Add(c.ttl)
+		}
+	}
+	entry.service = service
+}
+
+func (c *Cache[V]) Delete(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.entries[id]; found {
+		c.remove(el)
+	}
+}
+
+func (c *Cache[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Keys returns the ids currently cached, including cached misses
+func (c *Cache[V]) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.entries))
+	for id := range c.entries {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (c *Cache[V]) Stats() CacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries:   entries,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+func (c *Cache[V]) set(entry *cacheEntry[V], ttl time.Duration) {
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.entries[entry.id]; found {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[entry.id] = c.lru.PushFront(entry)
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove drops el from the cache, callers hold c.mu
+func (c *Cache[V]) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry[V]).id)
+}
@@ -0,0 +1,119 @@
+package injectorsdk
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCacheExpiry(t *testing.T) {
+	c := NewCache[string](200*time.Millisecond, 50*time.Millisecond, 0)
+	c.Set("hello", "http://hello")
+	c.SetNotFound("missing")
+
+	if v, notFound, ok := c.Get("hello"); !ok || notFound || v != "http://hello" {
+		t.Fatalf("got %q, %v, %v, want a hit", v, notFound, ok)
+	}
+	if _, notFound, ok := c.Get("missing"); !ok || !notFound {
+		t.Fatal("cached miss not served")
+	}
+
+	// Misses expire first, on their shorter TTL
+	time.Sleep(100 * time.Millisecond)
+	if _, _, ok := c.Get("missing"); ok {
+		t.Fatal("cached miss outlived the negative TTL")
+	}
+	if _, _, ok := c.Get("hello"); !ok {
+		t.Fatal("entry expired before its TTL")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, _, ok := c.Get("hello"); ok {
+		t.Fatal("entry outlived its TTL")
+	}
+
+	want := CacheStats{Entries: 0, Hits: 3, Misses: 2, Evictions: 2}
+	if got := c.Stats(); got != want {
+		t.Fatalf("stats %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheNegativeDisabled(t *testing.T) {
+	c := NewCache[string](time.Minute, 0, 0)
+	c.SetNotFound("missing")
+	if _, _, ok := c.Get("missing"); ok {
+		t.Fatal("miss cached without a negative TTL")
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	c := NewCache[int](0, time.Minute, 2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a") // b is now least recently used
+	c.Set("c", 3)
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry kept")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, _, ok := c.Get(id); !ok {
+			t.Fatalf("%s evicted", id)
+		}
+	}
+
+	// Replacing an entry does not count against the bound
+	c.Set("a", 10)
+	if v, _, _ := c.Get("a"); v != 10 {
+		t.Fatalf("got %d after replacing, want 10", v)
+	}
+	// Cached misses count like entries
+	c.SetNotFound("d")
+	if _, _, ok := c.Get("c"); ok {
+		t.Fatal("entry kept past the bound by a cached miss")
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions != 2 {
+		t.Fatalf("stats %+v, want 2 entries and 2 evictions", stats)
+	}
+}
+
+func TestCacheRefreshDelete(t *testing.T) {
+	c := NewCache[string](200*time.Millisecond, time.Minute, 0)
+
+	// Refresh only replaces what is cached
+	c.Refresh("hello", "http://hello")
+	if _, _, ok := c.Get("hello"); ok {
+		t.Fatal("refresh added an entry")
+	}
+
+	c.Set("hello", "http://hello")
+	time.Sleep(100 * time.Millisecond)
+	c.Refresh("hello", "http://hello-2")
+	if v, _, _ := c.Get("hello"); v != "http://hello-2" {
+		t.Fatalf("got %q after refresh, want http://hello-2", v)
+	}
+	// and keeps the entry's expiry
+	time.Sleep(150 * time.Millisecond)
+	if _, _, ok := c.Get("hello"); ok {
+		t.Fatal("refresh extended the entry's lifetime")
+	}
+
+	// A cached miss that now exists becomes an entry
+	c.SetNotFound("late")
+	c.Refresh("late", "http://late")
+	if v, notFound, ok := c.Get("late"); !ok || notFound || v != "http://late" {
+		t.Fatalf("got %q, %v, %v after refreshing a miss", v, notFound, ok)
+	}
+
+	c.Set("other", "http://other")
+	if keys := c.Keys(); !slices.Contains(keys, "late") || !slices.Contains(keys, "other") || len(keys) != 2 {
+		t.Fatalf("keys %v", keys)
+	}
+	c.Delete("late")
+	if _, _, ok := c.Get("late"); ok {
+		t.Fatal("deleted entry served")
+	}
+	c.Clear()
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Fatalf("%d entries after clear", stats.Entries)
+	}
+}
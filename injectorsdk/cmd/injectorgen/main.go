@@ -0,0 +1,200 @@
+// Command injectorgen generates a typed Deps struct from a function's
+// dependency manifest. Add to the function's package:
+//
+//	//go:generate go run injectorsdk/cmd/injectorgen
+//
+// Every dependency in the manifest needs a type, the Go type its factory
+// builds. The accessor is named after name, or the id if name is unset.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"injectorsdk"
+)
+
+type dependency struct {
+	injectorsdk.Dependency
+	Field string
+}
+
+var tmpl = template.Must(template.New("deps").Parse(`// Code generated by injectorgen from {{.Manifest}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"errors"
+
+	"injectorsdk"
+)
+
+// Deps holds the clients for the dependencies listed in {{.Manifest}}
+type Deps struct {
+{{- range .Deps}}
+	{{.Field}} {{.Type}}
+{{- end}}
+}
+
+// NewDeps resolves every dependency through container. Optional
+// dependencies that do not exist are left unset, any other failure is
+// returned.
+func NewDeps(ctx context.Context, container *injectorsdk.Container) (*Deps, error) {
+	var d Deps
+	var errs []error
+	var err error
+{{range .Deps}}
+	d.{{.Field}}, err = injectorsdk.Resolve[{{.Type}}](ctx, container, {{printf "%q" .ID}})
+	{{- if .Optional}}
+	if err != nil && !errors.Is(err, injectorsdk.ErrNotFound) {
+	{{- else}}
+	if err != nil {
+	{{- end}}
+		errs = append(errs, err)
+	}
+{{end}}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+{{range .Deps}}
+// {{.Name}} returns the {{printf "%q" .ID}} dependency{{if .Optional}}, unset if it does not exist{{end}}
+func (d *Deps) {{.Name}}() {{.Type}} {
+	return d.{{.Field}}
+}
+{{end}}`))
+
+func main() {
+	manifestPath := flag.String("manifest", "dependencies.yaml", "dependency manifest to read")
+	output := flag.String("o", "deps_gen.go", "Go file to write")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("injectorgen: ")
+
+	if *pkg == "" {
+		*pkg = "main"
+	}
+
+	src, err := generate(*manifestPath, *pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the formatted source of the Deps file for the manifest at
+// manifestPath
+func generate(manifestPath, pkg string) ([]byte, error) {
+	manifest, err := injectorsdk.LoadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, err := prepare(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestPath, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]any{
+		"Manifest": filepath.Base(manifestPath),
+		"Package":  pkg,
+		"Deps":     deps,
+	})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %w", err)
+	}
+	return src, nil
+}
+
+// prepare checks every dependency can get an accessor and picks the names
+func prepare(manifest *injectorsdk.Manifest) ([]dependency, error) {
+	deps := make([]dependency, 0, len(manifest.Dependencies))
+	names := make(map[string]string)
+	fields := make(map[string]string)
+
+	for _, dep := range manifest.Dependencies {
+		if dep.Type == "" {
+			return nil, fmt.Errorf("dependency %q has no type", dep.ID)
+		}
+		if dep.Name == "" {
+			dep.Name = exportedName(dep.ID)
+		}
+		if !token.IsIdentifier(dep.Name) || !token.IsExported(dep.Name) {
+			return nil, fmt.Errorf("dependency %q: %q is not an exported Go identifier", dep.ID, dep.Name)
+		}
+		if other, ok := names[dep.Name]; ok {
+			return nil, fmt.Errorf("dependencies %q and %q are both named %s", other, dep.ID, dep.Name)
+		}
+		names[dep.Name] = dep.ID
+
+		field := fieldName(dep.Name)
+		if other, ok := fields[field]; ok {
+			return nil, fmt.Errorf("dependencies %q and %q would both be stored in field %s", other, dep.ID, field)
+		}
+		fields[field] = dep.ID
+
+		deps = append(deps, dependency{Dependency: dep, Field: field})
+	}
+	return deps, nil
+}
+
+// exportedName turns an id like "audit-log" into AuditLog
+func exportedName(id string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range id {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fieldName unexports name, keeping initialisms intact: ACL becomes acl,
+// HTTPClient httpClient
+func fieldName(name string) string {
+	runes := []rune(name)
+	n := 0
+	for n < len(runes) && unicode.IsUpper(runes[n]) {
+		n++
+	}
+	if n > 1 && n < len(runes) {
+		// The last capital starts the next word
+		n--
+	}
+	for i := 0; i < n; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	field := string(runes)
+	if token.IsKeyword(field) {
+		field += "Dep"
+	}
+	return field
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"injectorsdk"
+)
+
+// TestGolden regenerates the Deps files committed next to the callers'
+// manifests
+func TestGolden(t *testing.T) {
+	for _, dir := range []string{"caller-ACL", "caller-minio"} {
+		t.Run(dir, func(t *testing.T) {
+			dir := filepath.Join("..", "..", "..", dir)
+			want, err := os.ReadFile(filepath.Join(dir, "deps_gen.go"))
+			if os.IsNotExist(err) {
+				t.Skip("caller not checked out next to the SDK")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := generate(filepath.Join(dir, "dependencies.yaml"), "main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("generated\n%s\nwant the committed deps_gen.go\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	deps, err := prepare(&injectorsdk.Manifest{Dependencies: []injectorsdk.Dependency{
+		{ID: "audit-log", Type: "*AuditLog"},
+		{ID: "acl", Name: "ACL", Type: "*ACLService"},
+		{ID: "http", Name: "HTTPClient", Type: "*http.Client"},
+		{ID: "types", Name: "Type", Type: "string"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, field string }{
+		{"AuditLog", "auditLog"},
+		{"ACL", "acl"},
+		{"HTTPClient", "httpClient"},
+		{"Type", "typeDep"},
+	}
+	for i, w := range want {
+		if deps[i].Name != w.name || deps[i].Field != w.field {
+			t.Errorf("%s: got %s and %s, want %s and %s", deps[i].ID, deps[i].Name, deps[i].Field, w.name, w.field)
+		}
+	}
+}
+
+func TestPrepareErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		deps []injectorsdk.Dependency
+		want string
+	}{
+		{"no type", []injectorsdk.Dependency{{ID: "acl"}}, "has no type"},
+		{"unexported name", []injectorsdk.Dependency{{ID: "acl", Name: "acl", Type: "*ACLService"}}, "not an exported Go identifier"},
+		{"invalid name", []injectorsdk.Dependency{{ID: "acl", Name: "A-CL", Type: "*ACLService"}}, "not an exported Go identifier"},
+		{"id without letters", []injectorsdk.Dependency{{ID: "42", Type: "*ACLService"}}, "not an exported Go identifier"},
+		{"same name", []injectorsdk.Dependency{
+			{ID: "audit-log", Type: "*AuditLog"},
+			{ID: "audit_log", Type: "*AuditLog"},
+		}, `"audit-log" and "audit_log" are both named AuditLog`},
+		{"same field", []injectorsdk.Dependency{
+			{ID: "acl", Name: "ACL", Type: "*ACLService"},
+			{ID: "acl-v2", Name: "Acl", Type: "*ACLService"},
+		}, `"acl" and "acl-v2" would both be stored in field acl`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := prepare(&injectorsdk.Manifest{Dependencies: tt.deps})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrNoFactory is returned when no factory is registered for a descriptor's Kind
+var ErrNoFactory = errors.New("no factory for service kind")
+
+// Factory builds a ready to use client from a resolved descriptor
+type Factory func(ctx context.Context, service Service) (any, error)
+
+// Container is the dependency injection container: it resolves ids through
+// a Resolver and turns the descriptors into clients with the Factory
+// registered for their Kind. Built clients are kept and handed out again
+// until the descriptor they were built from changes, so a warm function
+// instance sets each client up once.
+type Container struct {
+	resolver Resolver
+	secrets  SecretProvider
+
+	mu        sync.RWMutex
+	factories map[string]Factory
+	instances map[string]*instance
+}
+
+// instance is the pooled client for one id, or for one variant of an id
+// whose traffic is split
+type instance struct {
+	mu      sync.Mutex // held while building, so concurrent callers build once
+	service Service
+	client  any
+}
+
+// NewContainer returns a container resolving through resolver, with secret
+// references in descriptors looked up by SecretsFromEnv
+func NewContainer(resolver Resolver) *Container {
+	return &Container{
+		resolver:  resolver,
+		secrets:   SecretsFromEnv(),
+		factories: make(map[string]Factory),
+		instances: make(map[string]*instance),
+	}
+}
+
+// SetSecrets replaces the provider secret references are resolved with
+func (c *Container) SetSecrets(provider SecretProvider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.secrets = provider
+}
+
+// Register sets the factory for descriptors of the given kind, replacing
+// any previous one. Clients already built by the old factory are dropped.
+func (c *Container) Register(kind string, factory Factory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.factories[kind] = factory
+	for id, inst := range c.instances {
+		inst.mu.Lock()
+		stale := inst.client != nil && inst.service.Kind == kind
+		inst.mu.Unlock()
+		if stale {
+			delete(c.instances, id)
+		}
+	}
+}
+
+// Provide registers a typed factory for kind, e.g.
+//
+//	injectorsdk.Provide(c, "opa", func(ctx context.Context, svc injectorsdk.Service) (*ACLService, error) {
+//		return NewACLService(injectorsdk.NewBalancer(svc)), nil
+//	})
+func Provide[T any](c *Container, kind string, factory func(ctx context.Context, service Service) (T, error)) {
+	c.Register(kind, func(ctx context.Context, service Service) (any, error) {
+		return factory(ctx, service)
+	})
+}
+
+// Get resolves id and returns its client, building a new one only if the
+// descriptor changed since the last call
+func (c *Container) Get(ctx context.Context, id string) (any, error) {
+	service, err := c.resolver.Resolve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return c.build(ctx, id, service)
+}
+
+// Preload resolves every dependency in m in one batch and builds their
+// clients, so the first invocation does not pay for either. It fails if a
+// required dependency is missing, has another kind than the manifest says
+// or cannot be built. A nil manifest preloads nothing.
+func (c *Container) Preload(ctx context.Context, m *Manifest) error {
+	if m == nil {
+		return nil
+	}
+
+	services, failed := ResolveAll(ctx, c.resolver, m.IDs())
+
+	var errs []error
+	for _, dep := range m.Dependencies {
+		err := failed[dep.ID]
+		if err == nil {
+			service := services[dep.ID]
+			if dep.Kind != "" && service.Kind != dep.Kind {
+				err = fmt.Errorf("resolving %q: kind is %q, manifest wants %q", dep.ID, service.Kind, dep.Kind)
+			} else {
+				_, err = c.build(ctx, dep.ID, service)
+			}
+		}
+		if err != nil && !dep.Optional {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// build returns the pooled client for id, rebuilding it if service differs
+// from the descriptor it was built from
+func (c *Container) build(ctx context.Context, id string, service Service) (any, error) {
+	key := id
+	if service.Variant != "" {
+		key += "@" + service.Variant
+	}
+
+	c.mu.Lock()
+	factory, ok := c.factories[service.Kind]
+	secrets := c.secrets
+	inst := c.instances[key]
+	if inst == nil {
+		inst = &instance{}
+		c.instances[key] = inst
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("resolving %q: %w %q", id, ErrNoFactory, service.Kind)
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	// Clients with minted credentials are rebuilt shortly before those
+	// expire, from the fresh credentials the injector hands out by then
+	if inst.client != nil && sameDescriptor(inst.service, service) && inst.service.fresh() {
+		return inst.client, nil
+	}
+
+	// Secrets are only resolved here, so the plaintext values live in the
+	// built client and nowhere else
+	withSecrets, err := ResolveSecrets(ctx, secrets, service)
+	if err != nil {
+		return nil, err
+	}
+	client, err := factory(ctx, withSecrets)
+	if err != nil {
+		return nil, fmt.Errorf("building %q: %w", id, err)
+	}
+	// The previous client is left to the garbage collector rather than
+	// closed, since other invocations may still be using it
+	inst.service, inst.client = service, client
+	return client, nil
+}
+
+// sameDescriptor reports whether a client built from a is still valid for b.
+// Revisions identify a descriptor when the resolver reports them; without
+// them (e.g. in direct mode) the whole descriptor is compared.
+func sameDescriptor(a, b Service) bool {
+	if a.Revision != 0 && b.Revision != 0 {
+		return a.ID == b.ID && a.Revision == b.Revision && a.Variant == b.Variant
+	}
+	if len(a.Endpoints) > 0 {
+		// The address is just the endpoint the injector picked this time
+		a.ServiceAddress, b.ServiceAddress = "", ""
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Resolve resolves id in c and returns its client as a T, the type the
+// factory for the descriptor's Kind builds
+func Resolve[T any](ctx context.Context, c *Container, id string) (T, error) {
+	var zero T
+	client, err := c.Get(ctx, id)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := client.(T)
+	if !ok {
+		return zero, fmt.Errorf("resolving %q: got %T, want %v", id, client, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return typed, nil
+}
+
+// Inject fills every field of the struct target points to that carries an
+// inject tag with the client for the id in the tag, e.g.
+//
+//	type Deps struct {
+//		ACL *ACLService `inject:"acl"`
+//	}
+//
+// All fields are attempted and every failure is reported in the returned
+// error, so a misconfigured function can say at startup everything that is
+// missing or of the wrong type.
+func (c *Container) Inject(ctx context.Context, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("inject: target must be a non-nil pointer to a struct, got %T", target)
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		id, ok := field.Tag.Lookup("inject")
+		if !ok {
+			continue
+		}
+		if id == "" {
+			errs = append(errs, fmt.Errorf("inject: field %s.%s has an empty inject tag", t.Name(), field.Name))
+			continue
+		}
+		if !field.IsExported() {
+			errs = append(errs, fmt.Errorf("inject: field %s.%s must be exported", t.Name(), field.Name))
+			continue
+		}
+
+		client, err := c.Get(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inject: field %s.%s: %w", t.Name(), field.Name, err))
+			continue
+		}
+		cv := reflect.ValueOf(client)
+		if !cv.IsValid() || !cv.Type().AssignableTo(field.Type) {
+			errs = append(errs, fmt.Errorf("inject: field %s.%s: %q builds %T, not assignable to %v", t.Name(), field.Name, id, client, field.Type))
+			continue
+		}
+		v.Field(i).Set(cv)
+	}
+	return errors.Join(errs...)
+}
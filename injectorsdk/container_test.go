@@ -0,0 +1,182 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapResolver serves the descriptors in services and ErrNotFound otherwise
+type mapResolver struct {
+	mu       sync.Mutex
+	services map[string]Service
+}
+
+func (r *mapResolver) Resolve(ctx context.Context, id string) (Service, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	service, ok := r.services[id]
+	if !ok {
+		return Service{}, &Error{ID: id, Kind: ErrNotFound}
+	}
+	return service, nil
+}
+
+func (r *mapResolver) set(service Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.services[service.ID] = service
+}
+
+type fakeClient struct {
+	address string
+}
+
+// newTestContainer returns a container over services whose "http" factory
+// builds *fakeClient values and whose "broken" factory always fails, and a
+// counter of the clients built
+func newTestContainer(services ...Service) (*Container, *mapResolver, *int) {
+	r := &mapResolver{services: make(map[string]Service)}
+	for _, service := range services {
+		r.set(service)
+	}
+	c := NewContainer(r)
+	builds := 0
+	Provide(c, "http", func(ctx context.Context, service Service) (*fakeClient, error) {
+		builds++
+		return &fakeClient{address: service.ServiceAddress}, nil
+	})
+	Provide(c, "broken", func(ctx context.Context, service Service) (*fakeClient, error) {
+		return nil, errors.New("cannot connect")
+	})
+	return c, r, &builds
+}
+
+func TestResolveTyped(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newTestContainer(
+		Service{ID: "hello", Kind: "http", ServiceAddress: "http://hello"},
+		Service{ID: "plain", Kind: "unknown", ServiceAddress: "http://plain"},
+	)
+
+	client, err := Resolve[*fakeClient](ctx, c, "hello")
+	if err != nil || client.address != "http://hello" {
+		t.Fatalf("got %+v, %v", client, err)
+	}
+
+	_, err = Resolve[*strings.Builder](ctx, c, "hello")
+	if err == nil || !strings.Contains(err.Error(), "got *injectorsdk.fakeClient, want *strings.Builder") {
+		t.Fatalf("type mismatch: got %v", err)
+	}
+	if _, err := Resolve[*fakeClient](ctx, c, "plain"); !errors.Is(err, ErrNoFactory) {
+		t.Fatalf("got %v, want ErrNoFactory", err)
+	}
+	if _, err := Resolve[*fakeClient](ctx, c, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestContainerPooling(t *testing.T) {
+	ctx := context.Background()
+	hello := Service{ID: "hello", Kind: "http", ServiceAddress: "http://hello", Revision: 1}
+	c, r, builds := newTestContainer(hello)
+
+	get := func(wantAddress string, wantBuilds int) *fakeClient {
+		t.Helper()
+		client, err := Resolve[*fakeClient](ctx, c, "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.address != wantAddress || *builds != wantBuilds {
+			t.Fatalf("got %s after %d builds, want %s after %d", client.address, *builds, wantAddress, wantBuilds)
+		}
+		return client
+	}
+
+	first := get("http://hello", 1)
+	if get("http://hello", 1) != first {
+		t.Fatal("client rebuilt for the same descriptor")
+	}
+
+	// A new revision is a new descriptor, here with credentials that are
+	// about to expire
+	hello.Revision, hello.ServiceAddress = 2, "http://hello-2"
+	hello.Expires = time.Now().Add(30 * time.Second)
+	r.set(hello)
+	get("http://hello-2", 2)
+
+	// so the client is rebuilt at the same revision until the injector
+	// hands out fresh credentials
+	get("http://hello-2", 3)
+	hello.Expires = time.Now().Add(time.Hour)
+	r.set(hello)
+	get("http://hello-2", 4)
+	get("http://hello-2", 4)
+
+	// Without revisions any change to the descriptor counts, here the
+	// credentials in an attribute
+	direct := Service{ID: "hello", Kind: "http", ServiceAddress: "http://hello", Attributes: map[string]interface{}{"AccessKey": "a"}}
+	r.set(direct)
+	get("http://hello", 5)
+	get("http://hello", 5)
+	direct.Attributes = map[string]interface{}{"AccessKey": "b"}
+	r.set(direct)
+	get("http://hello", 6)
+
+	// Registering the kind again drops what the old factory built
+	Provide(c, "http", func(ctx context.Context, service Service) (*fakeClient, error) {
+		*builds += 10
+		return &fakeClient{address: service.ServiceAddress}, nil
+	})
+	get("http://hello", 16)
+}
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newTestContainer(
+		Service{ID: "hello", Kind: "http", ServiceAddress: "http://hello"},
+		Service{ID: "down", Kind: "broken", ServiceAddress: "http://down"},
+	)
+
+	var deps struct {
+		Hello   *fakeClient `inject:"hello"`
+		Skipped *fakeClient
+	}
+	if err := c.Inject(ctx, &deps); err != nil {
+		t.Fatal(err)
+	}
+	if deps.Hello == nil || deps.Hello.address != "http://hello" || deps.Skipped != nil {
+		t.Fatalf("injected %+v", deps)
+	}
+
+	// Every failing field is reported, not just the first
+	var bad struct {
+		Hello   *fakeClient      `inject:"hello"`
+		Missing *fakeClient      `inject:"missing"`
+		Down    *fakeClient      `inject:"down"`
+		Wrong   *strings.Builder `inject:"hello"`
+		Empty   *fakeClient      `inject:""`
+		private *fakeClient      `inject:"hello"`
+	}
+	err := c.Inject(ctx, &bad)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want it to wrap ErrNotFound", err)
+	}
+	for _, field := range []string{"Missing", "Down", "Wrong", "Empty", "private"} {
+		if !strings.Contains(err.Error(), "."+field+" ") && !strings.Contains(err.Error(), "."+field+":") {
+			t.Errorf("%s not reported in %v", field, err)
+		}
+	}
+	if strings.Contains(err.Error(), ".Hello") || bad.Hello == nil || bad.private != nil {
+		t.Fatalf("valid fields not injected: %v", err)
+	}
+
+	if err := c.Inject(ctx, deps); err == nil {
+		t.Fatal("injected into a struct value")
+	}
+}
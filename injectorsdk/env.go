@@ -0,0 +1,52 @@
+package injectorsdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvResolver is the direct call baseline: no lookup at all, descriptors
+// come from the function's environment. The id "hello" is read from
+// SERVICE_HELLO, falling back to SERVICE. A value is either the service
+// address or a whole descriptor as a JSON object.
+type EnvResolver struct{}
+
+func (EnvResolver) Resolve(ctx context.Context, id string) (Service, error) {
+	value, ok := os.LookupEnv(envName(id))
+	if !ok {
+		value, ok = os.LookupEnv("SERVICE")
+	}
+	if !ok {
+		return Service{}, &Error{ID: id, Kind: ErrNotFound, Err: fmt.Errorf("%s is not set", envName(id))}
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return Service{ID: id, ServiceName: id, ServiceAddress: value}, nil
+	}
+
+	var service Service
+	if err := json.Unmarshal([]byte(value), &service); err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrRejected, Err: fmt.Errorf("invalid %s: %w", envName(id), err)}
+	}
+	if service.ID == "" {
+		service.ID = id
+	}
+	return service, nil
+}
+
+// envName maps a service id to its variable, e.g. "hello-0" to SERVICE_HELLO_0
+func envName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, id)
+	return "SERVICE_" + name
+}
@@ -0,0 +1,58 @@
+package injectorsdk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Resolution failures are reported as *Error values matching one of these
+// with errors.Is
+var (
+	ErrNotFound     = errors.New("service not found")
+	ErrUnavailable  = errors.New("injector unavailable")
+	ErrUnauthorized = errors.New("not authorized to resolve service")
+	ErrRejected     = errors.New("request rejected by injector")
+)
+
+// Error describes why resolving ID failed. Kind is one of the sentinel
+// errors above, Err the underlying cause if there is one.
+type Error struct {
+	ID         string
+	StatusCode int
+	Kind       error
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("resolving %q: %v", e.ID, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// HTTPStatus suggests the status a function should answer with when
+// resolving a dependency failed with err
+func HTTPStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,238 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"injectorsdk/grpcapi"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCResolver resolves ids through the injector's gRPC API. Every call
+// shares one HTTP/2 connection.
+type GRPCResolver struct {
+	conn   *grpc.ClientConn
+	client grpcapi.InjectorClient
+	opts   Options
+	cache  *Cache[Service]
+	token  *tokenFile
+}
+
+// NewGRPCResolver returns a resolver for the injector's gRPC API at target,
+// e.g. injector.default.svc.cluster.local:5001. It connects on first use,
+// with TLS if opts.TLS is set.
+func NewGRPCResolver(target string, opts Options) (*GRPCResolver, error) {
+	opts = withDefaults(opts)
+
+	creds := insecure.NewCredentials()
+	if opts.TLS != nil {
+		creds = credentials.NewTLS(opts.TLS)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("invalid injector gRPC address %q: %w", target, err)
+	}
+
+	r := &GRPCResolver{conn: conn, client: grpcapi.NewInjectorClient(conn), opts: opts}
+	if opts.CacheTTL > 0 {
+		r.cache = NewCache[Service](opts.CacheTTL, opts.NegativeCacheTTL, opts.CacheMaxEntries)
+	}
+	if opts.TokenFile != "" {
+		r.token = &tokenFile{path: opts.TokenFile}
+	}
+	return r, nil
+}
+
+// Close closes the connection to the injector
+func (r *GRPCResolver) Close() error {
+	return r.conn.Close()
+}
+
+func (r *GRPCResolver) Resolve(ctx context.Context, id string) (Service, error) {
+	if r.cache != nil {
+		if service, notFound, ok := r.cache.Get(id); ok && service.fresh() {
+			if notFound {
+				return Service{}, &Error{ID: id, Kind: ErrNotFound}
+			}
+			return service, nil
+		}
+	}
+
+	var service Service
+	err := retry(ctx, r.opts, id, func() (err error) {
+		service, err = r.fetch(ctx, id)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && r.cache != nil {
+			r.cache.SetNotFound(id)
+		}
+		return Service{}, err
+	}
+	if r.cache != nil {
+		r.cache.Set(id, service)
+	}
+	return service, nil
+}
+
+// ResolveBatch resolves ids with one call per maxBatchSize ids, skipping
+// those the local cache can answer
+func (r *GRPCResolver) ResolveBatch(ctx context.Context, ids []string) (map[string]Service, map[string]error) {
+	services := make(map[string]Service, len(ids))
+	failed := make(map[string]error)
+
+	var pending []string
+	for _, id := range ids {
+		if r.cache != nil {
+			if service, notFound, ok := r.cache.Get(id); ok && service.fresh() {
+				if notFound {
+					failed[id] = &Error{ID: id, Kind: ErrNotFound}
+				} else {
+					services[id] = service
+				}
+				continue
+			}
+		}
+		pending = append(pending, id)
+	}
+
+	for len(pending) > 0 {
+		chunk := pending[:min(len(pending), maxBatchSize)]
+		pending = pending[len(chunk):]
+
+		var resp *grpcapi.BatchResolveResponse
+		err := retry(ctx, r.opts, strings.Join(chunk, ","), func() (err error) {
+			resp, err = r.fetchBatch(ctx, chunk)
+			return err
+		})
+		if err != nil {
+			for _, id := range chunk {
+				failed[id] = forID(err, id)
+			}
+			continue
+		}
+
+		for id, pb := range resp.Services {
+			service := serviceFromProto(pb)
+			services[id] = service
+			if r.cache != nil {
+				r.cache.Set(id, service)
+			}
+		}
+		for id, e := range resp.Errors {
+			err := &Error{ID: id, Kind: codeKind(codes.Code(e.Code))}
+			if err.Kind != ErrNotFound {
+				err.Err = errors.New(e.Message)
+			}
+			failed[id] = err
+			if err.Kind == ErrNotFound && r.cache != nil {
+				r.cache.SetNotFound(id)
+			}
+		}
+	}
+	return services, failed
+}
+
+func (r *GRPCResolver) fetch(ctx context.Context, id string) (Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	ctx, err := r.outgoing(ctx)
+	if err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrUnauthorized, Err: err}
+	}
+	pb, err := r.client.Resolve(ctx, &grpcapi.ResolveRequest{Id: id, RoutingKey: r.opts.RoutingKey})
+	if err != nil {
+		return Service{}, rpcError(id, err)
+	}
+	return serviceFromProto(pb), nil
+}
+
+// fetchBatch calls BatchResolve for ids. The error is set only if the call
+// as a whole failed.
+func (r *GRPCResolver) fetchBatch(ctx context.Context, ids []string) (*grpcapi.BatchResolveResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	joined := strings.Join(ids, ",")
+	ctx, err := r.outgoing(ctx)
+	if err != nil {
+		return nil, &Error{ID: joined, Kind: ErrUnauthorized, Err: err}
+	}
+	resp, err := r.client.BatchResolve(ctx, &grpcapi.BatchResolveRequest{Ids: ids, RoutingKey: r.opts.RoutingKey})
+	if err != nil {
+		return nil, rpcError(joined, err)
+	}
+	return resp, nil
+}
+
+// outgoing adds the metadata the HTTP resolver sends as headers
+func (r *GRPCResolver) outgoing(ctx context.Context) (context.Context, error) {
+	token := r.opts.Token
+	if r.token != nil {
+		var err error
+		if token, err = r.token.get(); err != nil {
+			return ctx, err
+		}
+	}
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	if r.opts.CallerID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-caller-id", r.opts.CallerID)
+	}
+	return ctx, nil
+}
+
+// serviceFromProto converts a gRPC descriptor. Attribute numbers stay
+// float64, as they are when decoded from the HTTP API's JSON.
+func serviceFromProto(pb *grpcapi.Service) Service {
+	s := Service{
+		ID:             pb.Id,
+		ServiceName:    pb.ServiceName,
+		ServiceAddress: pb.ServiceAddress,
+		Kind:           pb.Kind,
+		Revision:       pb.Revision,
+		Endpoints:      pb.Endpoints,
+		Strategy:       pb.Strategy,
+		Variant:        pb.Variant,
+		Attributes:     pb.Attributes.AsMap(),
+	}
+	if pb.Expires != nil {
+		s.Expires = pb.Expires.AsTime()
+	}
+	return s
+}
+
+// rpcError maps a failed call to an *Error
+func rpcError(id string, err error) error {
+	st := status.Convert(err)
+	e := &Error{ID: id, Kind: codeKind(st.Code())}
+	if e.Kind != ErrNotFound {
+		e.Err = errors.New(st.Message())
+	}
+	return e
+}
+
+// codeKind is the sentinel error for a gRPC status code, the counterpart of
+// statusKind. Only ErrUnavailable is worth retrying.
+func codeKind(code codes.Code) error {
+	switch code {
+	case codes.NotFound:
+		return ErrNotFound
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return ErrUnauthorized
+	case codes.InvalidArgument, codes.AlreadyExists, codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return ErrRejected
+	default:
+		return ErrUnavailable
+	}
+}
@@ -0,0 +1,148 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"injectorsdk/grpcapi"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeInjector resolves hello, fails busy as unavailable until it has been
+// asked for it twice, and knows nothing else. Batches serve only batch.
+type fakeInjector struct {
+	grpcapi.UnimplementedInjectorServer
+	calls   atomic.Int32
+	busy    atomic.Int32
+	expires time.Time
+}
+
+func (f *fakeInjector) Resolve(ctx context.Context, req *grpcapi.ResolveRequest) (*grpcapi.Service, error) {
+	f.calls.Add(1)
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer token" {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	switch req.Id {
+	case "hello":
+		attrs, _ := structpb.NewStruct(map[string]interface{}{"Region": "eu", "Replicas": 3})
+		return &grpcapi.Service{
+			Id: "hello", ServiceName: "hello", ServiceAddress: "http://hello", Revision: 2,
+			Variant: req.RoutingKey, Attributes: attrs, Expires: timestamppb.New(f.expires),
+		}, nil
+	case "busy":
+		if f.busy.Add(1) <= 2 {
+			return nil, status.Error(codes.Unavailable, "overloaded")
+		}
+		return &grpcapi.Service{Id: "busy", ServiceName: "busy", ServiceAddress: "http://busy"}, nil
+	default:
+		return nil, status.Error(codes.NotFound, "service not found")
+	}
+}
+
+func (f *fakeInjector) BatchResolve(ctx context.Context, req *grpcapi.BatchResolveRequest) (*grpcapi.BatchResolveResponse, error) {
+	f.calls.Add(1)
+	md, _ := metadata.FromIncomingContext(ctx)
+	resp := &grpcapi.BatchResolveResponse{
+		Services: make(map[string]*grpcapi.Service),
+		Errors:   make(map[string]*grpcapi.ResolveError),
+	}
+	for _, id := range req.Ids {
+		switch id {
+		case "batch":
+			// The caller id comes back as the variant
+			resp.Services[id] = &grpcapi.Service{Id: id, ServiceName: id, ServiceAddress: "http://batch", Variant: strings.Join(md.Get("x-caller-id"), ",")}
+		case "secret":
+			resp.Errors[id] = &grpcapi.ResolveError{Code: int32(codes.PermissionDenied), Message: "access denied"}
+		default:
+			resp.Errors[id] = &grpcapi.ResolveError{Code: int32(codes.NotFound), Message: "service not found"}
+		}
+	}
+	return resp, nil
+}
+
+func startFakeInjector(t *testing.T) (*fakeInjector, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeInjector{expires: time.Now().Add(time.Hour).Truncate(time.Second)}
+	srv := grpc.NewServer()
+	grpcapi.RegisterInjectorServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return fake, lis.Addr().String()
+}
+
+func TestGRPCResolver(t *testing.T) {
+	fake, addr := startFakeInjector(t)
+	r, err := NewGRPCResolver(addr, Options{
+		Token: "token", CallerID: "fn", RoutingKey: "canary",
+		Retries: 2, RetryBackoff: time.Millisecond,
+		CacheTTL: time.Minute, NegativeCacheTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	service, err := r.Resolve(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ServiceAddress != "http://hello" || service.Revision != 2 || service.Variant != "canary" ||
+		service.String("Region") != "eu" || service.Attributes["Replicas"] != 3.0 || !service.Expires.Equal(fake.expires) {
+		t.Fatalf("got %+v", service)
+	}
+	if _, err := r.Resolve(ctx, "hello"); err != nil || fake.calls.Load() != 1 {
+		t.Fatalf("cached resolution called the injector: %d calls, %v", fake.calls.Load(), err)
+	}
+
+	if _, err := r.Resolve(ctx, "busy"); err != nil {
+		t.Fatalf("unavailable injector not retried: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("got %v, want ErrNotFound", err)
+		}
+	}
+	if calls := fake.calls.Load(); calls != 5 {
+		t.Fatalf("%d calls, want 5 with the miss cached", calls)
+	}
+
+	// hello is answered from the cache, the fake would not know it
+	services, failed := r.ResolveBatch(ctx, []string{"hello", "batch", "secret", "other"})
+	if len(services) != 2 || services["hello"].ServiceAddress != "http://hello" || services["batch"].Variant != "fn" {
+		t.Fatalf("batch served %v", services)
+	}
+	if !errors.Is(failed["secret"], ErrUnauthorized) || !errors.Is(failed["other"], ErrNotFound) {
+		t.Fatalf("batch failed %v", failed)
+	}
+}
+
+func TestGRPCResolverUnauthenticated(t *testing.T) {
+	_, addr := startFakeInjector(t)
+	r, err := NewGRPCResolver(addr, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Resolve(context.Background(), "hello"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v, want ErrUnauthorized", err)
+	}
+}
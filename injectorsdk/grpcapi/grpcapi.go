@@ -0,0 +1,14 @@
+// Package grpcapi holds the protobuf contract of the injector's gRPC API,
+// injector.proto, and the code generated from it: the messages, the
+// InjectorServer the injector implements and the InjectorClient behind the
+// SDK's GRPCResolver. It lives in the SDK so both sides can import it.
+//
+// Descriptor attributes are free-form and travel as a google.protobuf.Struct,
+// in which every number is a double. The injector turns whole numbers back
+// into integers, as the HTTP API does.
+//
+// The code is generated with protoc-gen-go v1.34.1 and protoc-gen-go-grpc
+// v1.5.1, matching the protobuf and grpc modules in go.mod.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative injector.proto
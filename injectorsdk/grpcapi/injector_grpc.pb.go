@@ -0,0 +1,261 @@
+// The injector's gRPC API, serving the same registry as the HTTP API with
+// the same access checks, variant and endpoint selection and credentials.
+// See grpcapi.go for how to regenerate the Go code.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: injector.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Injector_Resolve_FullMethodName      = "/injector.Injector/Resolve"
+	Injector_BatchResolve_FullMethodName = "/injector.Injector/BatchResolve"
+	Injector_Watch_FullMethodName        = "/injector.Injector/Watch"
+	Injector_Register_FullMethodName     = "/injector.Injector/Register"
+)
+
+// InjectorClient is the client API for Injector service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Injector resolves and registers service descriptors
+type InjectorClient interface {
+	// Resolve serves the descriptor of a service, with code NOT_FOUND if it
+	// is not registered
+	Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*Service, error)
+	// BatchResolve serves several descriptors at once, with an error for
+	// every id that could not be resolved
+	BatchResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (*BatchResolveResponse, error)
+	// Watch streams changes to one service, starting with its current state,
+	// or to every service
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// Register creates a service, or replaces it when replace is set
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*Service, error)
+}
+
+type injectorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInjectorClient(cc grpc.ClientConnInterface) InjectorClient {
+	return &injectorClient{cc}
+}
+
+func (c *injectorClient) Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*Service, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Service)
+	err := c.cc.Invoke(ctx, Injector_Resolve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *injectorClient) BatchResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (*BatchResolveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchResolveResponse)
+	err := c.cc.Invoke(ctx, Injector_BatchResolve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *injectorClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Injector_ServiceDesc.Streams[0], Injector_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Injector_WatchClient = grpc.ServerStreamingClient[Event]
+
+func (c *injectorClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*Service, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Service)
+	err := c.cc.Invoke(ctx, Injector_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InjectorServer is the server API for Injector service.
+// All implementations must embed UnimplementedInjectorServer
+// for forward compatibility.
+//
+// Injector resolves and registers service descriptors
+type InjectorServer interface {
+	// Resolve serves the descriptor of a service, with code NOT_FOUND if it
+	// is not registered
+	Resolve(context.Context, *ResolveRequest) (*Service, error)
+	// BatchResolve serves several descriptors at once, with an error for
+	// every id that could not be resolved
+	BatchResolve(context.Context, *BatchResolveRequest) (*BatchResolveResponse, error)
+	// Watch streams changes to one service, starting with its current state,
+	// or to every service
+	Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error
+	// Register creates a service, or replaces it when replace is set
+	Register(context.Context, *RegisterRequest) (*Service, error)
+	mustEmbedUnimplementedInjectorServer()
+}
+
+// UnimplementedInjectorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedInjectorServer struct{}
+
+func (UnimplementedInjectorServer) Resolve(context.Context, *ResolveRequest) (*Service, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedInjectorServer) BatchResolve(context.Context, *BatchResolveRequest) (*BatchResolveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchResolve not implemented")
+}
+func (UnimplementedInjectorServer) Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedInjectorServer) Register(context.Context, *RegisterRequest) (*Service, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedInjectorServer) mustEmbedUnimplementedInjectorServer() {}
+func (UnimplementedInjectorServer) testEmbeddedByValue()                  {}
+
+// UnsafeInjectorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InjectorServer will
+// result in compilation errors.
+type UnsafeInjectorServer interface {
+	mustEmbedUnimplementedInjectorServer()
+}
+
+func RegisterInjectorServer(s grpc.ServiceRegistrar, srv InjectorServer) {
+	// If the following call pancis, it indicates UnimplementedInjectorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Injector_ServiceDesc, srv)
+}
+
+func _Injector_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InjectorServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Injector_Resolve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InjectorServer).Resolve(ctx, req.(*ResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Injector_BatchResolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InjectorServer).BatchResolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Injector_BatchResolve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InjectorServer).BatchResolve(ctx, req.(*BatchResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Injector_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InjectorServer).Watch(m, &grpc.GenericServerStream[WatchRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Injector_WatchServer = grpc.ServerStreamingServer[Event]
+
+func _Injector_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InjectorServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Injector_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InjectorServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Injector_ServiceDesc is the grpc.ServiceDesc for Injector service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Injector_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "injector.Injector",
+	HandlerType: (*InjectorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Resolve",
+			Handler:    _Injector_Resolve_Handler,
+		},
+		{
+			MethodName: "BatchResolve",
+			Handler:    _Injector_BatchResolve_Handler,
+		},
+		{
+			MethodName: "Register",
+			Handler:    _Injector_Register_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Injector_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "injector.proto",
+}
@@ -0,0 +1,86 @@
+package injectorsdk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest lists the services a function depends on, e.g.
+//
+//	dependencies:
+//	  - id: acl
+//	    kind: opa
+//	    name: ACL
+//	    type: "*ACLService"
+//	  - id: audit-log
+//	    optional: true
+//
+// JSON manifests work as well, being valid YAML. Name and Type are only
+// used by injectorgen, which generates typed accessors from a manifest.
+type Manifest struct {
+	Dependencies []Dependency `json:"dependencies" yaml:"dependencies"`
+}
+
+// Dependency is one service in a Manifest. Kind, if set, must match the
+// descriptor's Kind. A missing optional dependency does not fail startup.
+type Dependency struct {
+	ID       string `json:"id" yaml:"id"`
+	Kind     string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Optional bool   `json:"optional,omitempty" yaml:"optional,omitempty"`
+	// Name is the generated accessor, derived from ID if empty
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Type is the Go type the factory for Kind builds, e.g. *ACLService
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(m.Dependencies))
+	for i, dep := range m.Dependencies {
+		if dep.ID == "" {
+			return nil, fmt.Errorf("manifest %s: dependency %d has no id", path, i)
+		}
+		if seen[dep.ID] {
+			return nil, fmt.Errorf("manifest %s: %q is listed twice", path, dep.ID)
+		}
+		seen[dep.ID] = true
+	}
+	return &m, nil
+}
+
+// LoadManifestFromEnv loads the manifest at INJECTOR_MANIFEST, or
+// dependencies.yaml if that is unset. A missing default manifest is not an
+// error, the function then just has none and nil is returned.
+func LoadManifestFromEnv() (*Manifest, error) {
+	path := os.Getenv("INJECTOR_MANIFEST")
+	if path != "" {
+		return LoadManifest(path)
+	}
+
+	m, err := LoadManifest("dependencies.yaml")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// IDs returns the ids of all dependencies in manifest order
+func (m *Manifest) IDs() []string {
+	ids := make([]string, len(m.Dependencies))
+	for i, dep := range m.Dependencies {
+		ids[i] = dep.ID
+	}
+	return ids
+}
@@ -0,0 +1,304 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoResolver reads descriptors straight from the registry's Mongo
+// collection inside the function process, like the SDK injector does. Its
+// cache follows the collection's change stream, and services with variants
+// get one picked per resolution as the injector would.
+type MongoResolver struct {
+	collection *mongo.Collection
+	opts       Options
+	cache      *Cache[document]
+}
+
+// document is a registry document as cached, before variant selection
+type document struct {
+	service  Service
+	variants []Variant
+}
+
+func NewMongoResolver(ctx context.Context, mongoURI string, opts Options) (*MongoResolver, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+
+	// Decode nested documents as maps so attributes look like they do in JSON
+	clientOpts := options.Client().ApplyURI(mongoURI).SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB connection error: %w", err)
+	}
+
+	r := &MongoResolver{
+		collection: client.Database("services").Collection("services"),
+		opts:       opts,
+	}
+	if opts.CacheTTL > 0 {
+		r.cache = NewCache[document](opts.CacheTTL, opts.NegativeCacheTTL, opts.CacheMaxEntries)
+		go r.watch(context.Background())
+	}
+	return r, nil
+}
+
+// CacheStats reports the counters of the local cache, zero without one
+func (r *MongoResolver) CacheStats() CacheStats {
+	if r.cache == nil {
+		return CacheStats{}
+	}
+	return r.cache.Stats()
+}
+
+// serve is the descriptor a resolution of doc returns. A service registered
+// with endpoints only gets one of them as its address, like the injector
+// serves it.
+func (r *MongoResolver) serve(doc document) Service {
+	service := pickVariant(doc.service, doc.variants, r.opts.RoutingKey)
+	if service.ServiceAddress == "" && len(service.Endpoints) > 0 {
+		service.ServiceAddress = service.Endpoints[rand.Intn(len(service.Endpoints))]
+	}
+	return service
+}
+
+func (r *MongoResolver) Resolve(ctx context.Context, id string) (Service, error) {
+	if r.cache != nil {
+		if doc, notFound, ok := r.cache.Get(id); ok {
+			if notFound {
+				return Service{}, &Error{ID: id, Kind: ErrNotFound}
+			}
+			return r.serve(doc), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	var raw bson.M
+	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 0}})
+	err := r.collection.FindOne(ctx, bson.D{{Key: "id", Value: id}}, opts).Decode(&raw)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if r.cache != nil {
+			r.cache.SetNotFound(id)
+		}
+		return Service{}, &Error{ID: id, Kind: ErrNotFound}
+	}
+	if err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrUnavailable, Err: err}
+	}
+
+	doc := documentFrom(raw)
+	if r.cache != nil {
+		r.cache.Set(id, doc)
+	}
+	return r.serve(doc), nil
+}
+
+// documentFrom splits a registry document into the fixed descriptor fields,
+// its variants and its attributes
+func documentFrom(raw bson.M) document {
+	var doc document
+	service := Service{Attributes: make(map[string]interface{})}
+	for k, v := range raw {
+		switch k {
+		case "id":
+			service.ID, _ = v.(string)
+		case "ServiceName":
+			service.ServiceName, _ = v.(string)
+		case "ServiceAddress":
+			service.ServiceAddress, _ = v.(string)
+		case "Kind":
+			service.Kind, _ = v.(string)
+		case "Endpoints":
+			items, _ := v.(bson.A)
+			for _, item := range items {
+				if endpoint, ok := item.(string); ok {
+					service.Endpoints = append(service.Endpoints, endpoint)
+				}
+			}
+		case "Strategy":
+			service.Strategy, _ = v.(string)
+		case "Revision":
+			switch rev := v.(type) {
+			case int32:
+				service.Revision = int64(rev)
+			case int64:
+				service.Revision = rev
+			}
+		case "Variants":
+			doc.variants = variantsFromDocument(v)
+		case "_id":
+		default:
+			service.Attributes[k] = v
+		}
+	}
+	doc.service = service
+	return doc
+}
+
+// ResolveBatch looks up every id the local cache cannot answer with a single
+// $in query
+func (r *MongoResolver) ResolveBatch(ctx context.Context, ids []string) (map[string]Service, map[string]error) {
+	services := make(map[string]Service, len(ids))
+	failed := make(map[string]error)
+
+	var pending []string
+	for _, id := range ids {
+		if r.cache != nil {
+			if doc, notFound, ok := r.cache.Get(id); ok {
+				if notFound {
+					failed[id] = &Error{ID: id, Kind: ErrNotFound}
+				} else {
+					services[id] = r.serve(doc)
+				}
+				continue
+			}
+		}
+		pending = append(pending, id)
+	}
+	if len(pending) == 0 {
+		return services, failed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 0}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: pending}}}}, opts)
+	if err != nil {
+		for _, id := range pending {
+			failed[id] = &Error{ID: id, Kind: ErrUnavailable, Err: err}
+		}
+		return services, failed
+	}
+	var raws []bson.M
+	if err := cursor.All(ctx, &raws); err != nil {
+		for _, id := range pending {
+			failed[id] = &Error{ID: id, Kind: ErrUnavailable, Err: err}
+		}
+		return services, failed
+	}
+
+	for _, raw := range raws {
+		doc := documentFrom(raw)
+		services[doc.service.ID] = r.serve(doc)
+		if r.cache != nil {
+			r.cache.Set(doc.service.ID, doc)
+		}
+	}
+	for _, id := range pending {
+		if _, ok := services[id]; !ok {
+			failed[id] = &Error{ID: id, Kind: ErrNotFound}
+			if r.cache != nil {
+				r.cache.SetNotFound(id)
+			}
+		}
+	}
+	return services, failed
+}
+
+// watch keeps the cache in sync with the services collection. It follows the
+// collection's change stream and falls back to polling the cached ids when
+// change streams are unavailable (a standalone mongod has none).
+func (r *MongoResolver) watch(ctx context.Context) {
+	for {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		stream, err := r.collection.Watch(ctx, mongo.Pipeline{}, opts)
+		if err != nil {
+			r.poll(ctx)
+			return
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType string `bson:"operationType"`
+				FullDocument  bson.M `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				r.cache.Clear()
+				continue
+			}
+			switch change.OperationType {
+			case "insert", "update", "replace":
+				if change.FullDocument != nil {
+					doc := documentFrom(change.FullDocument)
+					r.cache.Refresh(doc.service.ID, doc)
+				}
+			default:
+				// Deletes only carry the Mongo _id, drop and refetch lazily
+				r.cache.Clear()
+			}
+		}
+		stream.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The stream broke, so changes may have been missed meanwhile
+		r.cache.Clear()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// poll re-reads every cached id each PollInterval, refreshing changed
+// entries and evicting deleted ones
+func (r *MongoResolver) poll(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if ids := r.cache.Keys(); len(ids) > 0 {
+			r.reload(ctx, ids)
+		}
+	}
+}
+
+func (r *MongoResolver) reload(ctx context.Context, ids []string) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 0}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}}, opts)
+	if err != nil {
+		return
+	}
+	var raws []bson.M
+	if err := cursor.All(ctx, &raws); err != nil {
+		return
+	}
+
+	found := make(map[string]document, len(raws))
+	for _, raw := range raws {
+		doc := documentFrom(raw)
+		found[doc.service.ID] = doc
+	}
+	for _, id := range ids {
+		if doc, ok := found[id]; ok {
+			r.cache.Refresh(id, doc)
+		} else {
+			r.cache.Delete(id)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// unreachableMongo fails server selection quickly, as a registry without a
+// running mongod would
+const unreachableMongo = "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=50&connectTimeoutMS=50"
+
+func TestMongoResolverPollFallback(t *testing.T) {
+	r, err := NewMongoResolver(context.Background(), unreachableMongo, Options{CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.opts.PollInterval != 5*time.Second {
+		t.Fatalf("poll interval %v, want the 5s default", r.opts.PollInterval)
+	}
+
+	// watch finds no change stream and polls, which panicked with a zero
+	// interval and took the process down
+	r, err = NewMongoResolver(context.Background(), unreachableMongo, Options{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.cache = NewCache[document](time.Minute, 0, 0)
+	r.cache.Set("hello", document{service: Service{ID: "hello", ServiceAddress: "http://hello"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	r.watch(ctx)
+
+	// Polls that cannot reach the registry keep what is cached
+	service, err := r.Resolve(context.Background(), "hello")
+	if err != nil || service.ServiceAddress != "http://hello" {
+		t.Fatalf("got %+v, %v, want the cached descriptor", service, err)
+	}
+
+	var resolveErr *Error
+	if _, err := r.Resolve(context.Background(), "other"); !errors.As(err, &resolveErr) || resolveErr.Kind != ErrUnavailable {
+		t.Fatalf("got %v, want unavailable", err)
+	}
+}
+
+func TestMongoResolverServesEndpoint(t *testing.T) {
+	r := &MongoResolver{}
+	doc := documentFrom(bson.M{"id": "opa", "ServiceName": "opa", "Endpoints": bson.A{"http://opa-0", "http://opa-1"}})
+	for i := 0; i < 10; i++ {
+		if service := r.serve(doc); service.ServiceAddress != "http://opa-0" && service.ServiceAddress != "http://opa-1" {
+			t.Fatalf("served address %q for a service with endpoints only", service.ServiceAddress)
+		}
+	}
+}
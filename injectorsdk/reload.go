@@ -0,0 +1,77 @@
+package injectorsdk
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds a value read from files that may be replaced while the
+// process runs, such as certificates rotated by cert-manager or keys mounted
+// from a ConfigMap. The files are checked for changes at most once per
+// interval and read again with load when any of them changed.
+type Reloader[T any] struct {
+	files    []string
+	load     func() (T, error)
+	interval time.Duration
+
+	mu      sync.Mutex
+	value   T
+	modTime time.Time
+	checked time.Time
+}
+
+// NewReloader reads files with load, failing if that does
+func NewReloader[T any](interval time.Duration, load func() (T, error), files ...string) (*Reloader[T], error) {
+	r := &Reloader[T]{files: files, load: load, interval: interval}
+	modTime, err := latestModTime(files)
+	if err != nil {
+		return nil, err
+	}
+	if r.value, err = load(); err != nil {
+		return nil, err
+	}
+	r.modTime, r.checked = modTime, time.Now()
+	return r, nil
+}
+
+// Get returns the value, read again first if the files changed. If that
+// fails, e.g. while a rotation has replaced only some of the files, the
+// previous value is returned along with the error.
+func (r *Reloader[T]) Get() (T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < r.interval {
+		return r.value, nil
+	}
+	r.checked = time.Now()
+
+	modTime, err := latestModTime(r.files)
+	if err != nil {
+		return r.value, err
+	}
+	if modTime.Equal(r.modTime) {
+		return r.value, nil
+	}
+	value, err := r.load()
+	if err != nil {
+		return r.value, err
+	}
+	r.value, r.modTime = value, modTime
+	return value, nil
+}
+
+func latestModTime(files []string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
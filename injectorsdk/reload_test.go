@@ -0,0 +1,72 @@
+package injectorsdk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "value")
+	write := func(value string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loads := 0
+	load := func() (string, error) {
+		loads++
+		data, err := os.ReadFile(path)
+		if string(data) == "invalid" {
+			return "", errors.New("invalid value")
+		}
+		return string(data), err
+	}
+	get := func(r *Reloader[string], want string, wantErr bool) {
+		t.Helper()
+		got, err := r.Get()
+		if got != want || (err != nil) != wantErr {
+			t.Fatalf("got %q, %v, want %q with error %v", got, err, want, wantErr)
+		}
+	}
+
+	now := time.Now()
+	write("first", now)
+	r, err := NewReloader(0, load, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(r, "first", false)
+	if loads != 1 {
+		t.Fatalf("loaded %d times for an unchanged file, want 1", loads)
+	}
+
+	write("second", now.Add(time.Minute))
+	get(r, "second", false)
+
+	// A failed reload keeps the previous value
+	write("invalid", now.Add(2*time.Minute))
+	get(r, "second", true)
+	write("third", now.Add(3*time.Minute))
+	get(r, "third", false)
+
+	os.Remove(path)
+	get(r, "third", true)
+
+	if _, err := NewReloader(0, load, path); err == nil {
+		t.Fatal("created without its file")
+	}
+	write("late", now.Add(4*time.Minute))
+	slow, err := NewReloader(time.Hour, load, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("ignored", now.Add(5*time.Minute))
+	get(slow, "late", false)
+}
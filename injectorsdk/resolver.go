@@ -0,0 +1,486 @@
+package injectorsdk
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver turns a service id into its descriptor
+type Resolver interface {
+	Resolve(ctx context.Context, id string) (Service, error)
+}
+
+// BatchResolver is implemented by resolvers that can look up several ids in
+// one round trip. Every id ends up in exactly one of the two maps.
+type BatchResolver interface {
+	ResolveBatch(ctx context.Context, ids []string) (map[string]Service, map[string]error)
+}
+
+// maxBatchSize is the most ids the injector accepts in one batch request
+const maxBatchSize = 100
+
+// ResolveAll resolves ids in one batch if r supports it, one by one otherwise
+func ResolveAll(ctx context.Context, r Resolver, ids []string) (map[string]Service, map[string]error) {
+	if br, ok := r.(BatchResolver); ok {
+		return br.ResolveBatch(ctx, ids)
+	}
+
+	services := make(map[string]Service, len(ids))
+	failed := make(map[string]error)
+	for _, id := range ids {
+		service, err := r.Resolve(ctx, id)
+		if err != nil {
+			failed[id] = err
+			continue
+		}
+		services[id] = service
+	}
+	return services, failed
+}
+
+// Options tunes a resolver. The zero value means no retries and no local
+// cache, so every Resolve reaches the injector.
+type Options struct {
+	// Timeout bounds a single attempt, 5s if zero
+	Timeout time.Duration
+	// Retries is the number of extra attempts when the injector is unavailable
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled on each one
+	RetryBackoff time.Duration
+	// CacheTTL enables a local cache of resolved descriptors
+	CacheTTL time.Duration
+	// NegativeCacheTTL caches not-found answers, only with CacheTTL set
+	NegativeCacheTTL time.Duration
+	// CacheMaxEntries bounds the local cache, unbounded if zero
+	CacheMaxEntries int
+	// PollInterval is how often a MongoResolver re-reads its cached ids
+	// when the registry has no change streams, 5s if zero
+	PollInterval time.Duration
+	// RoutingKey makes the injector, or a MongoResolver itself, pick the
+	// same variant of a service with split traffic on every resolution
+	RoutingKey string
+	// CallerID identifies the function to the injector, which mints
+	// credentials scoped to it. With authentication on, the injector uses
+	// the token's identity instead.
+	CallerID string
+	// Token is sent as bearer token to an injector requiring authentication
+	Token string
+	// TokenFile is read for the bearer token instead, e.g. a projected
+	// service account token, and re-read as it rotates
+	TokenFile string
+	// TLS configures connections to an https:// injector or its gRPC API,
+	// see LoadClientTLS
+	TLS *tls.Config
+}
+
+// HTTPResolver resolves ids through the injector's HTTP API
+type HTTPResolver struct {
+	baseURL string
+	client  *http.Client
+	opts    Options
+	cache   *Cache[Service]
+	token   *tokenFile
+}
+
+// NewHTTPResolver returns a resolver for the injector at injectorURL, e.g.
+// http://injector.default.svc.cluster.local. A unix:///path/to/socket URL
+// dials the injector's Unix socket instead of TCP.
+func NewHTTPResolver(injectorURL string, opts Options) *HTTPResolver {
+	opts = withDefaults(opts)
+
+	r := &HTTPResolver{
+		baseURL: strings.TrimSuffix(injectorURL, "/"),
+		opts:    opts,
+	}
+	r.client, r.baseURL = newHTTPClient(r.baseURL, opts.TLS)
+	if opts.CacheTTL > 0 {
+		r.cache = NewCache[Service](opts.CacheTTL, opts.NegativeCacheTTL, opts.CacheMaxEntries)
+	}
+	if opts.TokenFile != "" {
+		r.token = &tokenFile{path: opts.TokenFile}
+	}
+	return r
+}
+
+// withDefaults fills in the timeout and retry backoff of the injector
+// resolvers
+func withDefaults(opts Options) Options {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 50 * time.Millisecond
+	}
+	return opts
+}
+
+// Resolution modes, selected with INJECTOR_MODE
+const (
+	ModeDirect    = "direct"    // descriptors from environment variables
+	ModeSDK       = "sdk"       // in-process lookups in MongoDB
+	ModeSidecar   = "sidecar"   // injector container in the same pod
+	ModeDaemonSet = "daemonset" // injector DaemonSet on the node
+	ModeGRPC      = "grpc"      // the injector's gRPC API
+)
+
+// NewResolverFromEnv builds the Resolver for the mode in INJECTOR_MODE
+// (daemonset if unset), so one function binary can run every experiment.
+//
+// The sidecar and daemonset modes read INJECTOR_URL, the grpc mode
+// INJECTOR_GRPC_ADDR and the sdk mode MONGO_URI and INJECTOR_POLL_INTERVAL.
+// All modes but direct honour INJECTOR_TIMEOUT, INJECTOR_RETRIES,
+// INJECTOR_CACHE_TTL, INJECTOR_NEGATIVE_CACHE_TTL, INJECTOR_CACHE_MAX_ENTRIES
+// and INJECTOR_ROUTING_KEY, the modes calling an injector also
+// INJECTOR_CALLER_ID, INJECTOR_TOKEN, INJECTOR_TOKEN_FILE and, for an
+// https:// INJECTOR_URL or gRPC over TLS, INJECTOR_CA_FILE,
+// INJECTOR_CERT_FILE and INJECTOR_KEY_FILE.
+func NewResolverFromEnv() (Resolver, error) {
+	mode := os.Getenv("INJECTOR_MODE")
+	if mode == "" {
+		mode = ModeDaemonSet
+	}
+
+	opts := Options{
+		Timeout:          durationEnv("INJECTOR_TIMEOUT", 0),
+		Retries:          intEnv("INJECTOR_RETRIES", 2),
+		CacheTTL:         durationEnv("INJECTOR_CACHE_TTL", 0),
+		NegativeCacheTTL: durationEnv("INJECTOR_NEGATIVE_CACHE_TTL", 0),
+		CacheMaxEntries:  intEnv("INJECTOR_CACHE_MAX_ENTRIES", 1000),
+		PollInterval:     durationEnv("INJECTOR_POLL_INTERVAL", 0),
+		RoutingKey:       os.Getenv("INJECTOR_ROUTING_KEY"),
+		// Knative sets K_SERVICE to the name of the function's service
+		CallerID:  envOr("INJECTOR_CALLER_ID", os.Getenv("K_SERVICE")),
+		Token:     os.Getenv("INJECTOR_TOKEN"),
+		TokenFile: os.Getenv("INJECTOR_TOKEN_FILE"),
+	}
+
+	if caFile, certFile := os.Getenv("INJECTOR_CA_FILE"), os.Getenv("INJECTOR_CERT_FILE"); caFile != "" || certFile != "" {
+		config, err := LoadClientTLS(caFile, certFile, os.Getenv("INJECTOR_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = config
+	}
+
+	switch mode {
+	case ModeDirect:
+		return EnvResolver{}, nil
+	case ModeSDK:
+		mongoURI := os.Getenv("MONGO_URI")
+		if mongoURI == "" {
+			mongoURI = "mongodb://mongo.default.svc.cluster.local:27017"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return NewMongoResolver(ctx, mongoURI, opts)
+	case ModeSidecar:
+		return NewHTTPResolver(envOr("INJECTOR_URL", "http://localhost:5000"), opts), nil
+	case ModeDaemonSet:
+		return NewHTTPResolver(envOr("INJECTOR_URL", "http://injector.default.svc.cluster.local"), opts), nil
+	case ModeGRPC:
+		return NewGRPCResolver(envOr("INJECTOR_GRPC_ADDR", "injector.default.svc.cluster.local:5001"), opts)
+	default:
+		return nil, fmt.Errorf("unknown INJECTOR_MODE %q", mode)
+	}
+}
+
+// newHTTPClient returns a client with a transport tuned for many small
+// requests to one host, and the base URL to use with it
+func newHTTPClient(injectorURL string, tlsConfig *tls.Config) (*http.Client, string) {
+	dialer := &net.Dialer{Timeout: 2 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	if socketPath, ok := strings.CutPrefix(injectorURL, "unix://"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		// The host is ignored by the dialer but still required in request URLs
+		injectorURL = "http://injector"
+	}
+
+	return &http.Client{Transport: transport}, injectorURL
+}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, id string) (Service, error) {
+	if r.cache != nil {
+		if service, notFound, ok := r.cache.Get(id); ok && service.fresh() {
+			if notFound {
+				return Service{}, &Error{ID: id, StatusCode: http.StatusNotFound, Kind: ErrNotFound}
+			}
+			return service, nil
+		}
+	}
+
+	var service Service
+	err := retry(ctx, r.opts, id, func() (err error) {
+		service, err = r.fetch(ctx, id)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && r.cache != nil {
+			r.cache.SetNotFound(id)
+		}
+		return Service{}, err
+	}
+	if r.cache != nil {
+		r.cache.Set(id, service)
+	}
+	return service, nil
+}
+
+// ResolveBatch resolves ids with one request per maxBatchSize ids, skipping
+// those the local cache can answer
+func (r *HTTPResolver) ResolveBatch(ctx context.Context, ids []string) (map[string]Service, map[string]error) {
+	services := make(map[string]Service, len(ids))
+	failed := make(map[string]error)
+
+	var pending []string
+	for _, id := range ids {
+		if r.cache != nil {
+			if service, notFound, ok := r.cache.Get(id); ok && service.fresh() {
+				if notFound {
+					failed[id] = &Error{ID: id, StatusCode: http.StatusNotFound, Kind: ErrNotFound}
+				} else {
+					services[id] = service
+				}
+				continue
+			}
+		}
+		pending = append(pending, id)
+	}
+
+	for len(pending) > 0 {
+		chunk := pending[:min(len(pending), maxBatchSize)]
+		pending = pending[len(chunk):]
+
+		var found map[string]Service
+		var missing map[string]error
+		err := retry(ctx, r.opts, strings.Join(chunk, ","), func() (err error) {
+			found, missing, err = r.fetchBatch(ctx, chunk)
+			return err
+		})
+		if err != nil {
+			for _, id := range chunk {
+				failed[id] = forID(err, id)
+			}
+			continue
+		}
+
+		for id, service := range found {
+			services[id] = service
+			if r.cache != nil {
+				r.cache.Set(id, service)
+			}
+		}
+		for id, err := range missing {
+			failed[id] = err
+			if errors.Is(err, ErrNotFound) && r.cache != nil {
+				r.cache.SetNotFound(id)
+			}
+		}
+	}
+	return services, failed
+}
+
+// retry runs attempt until it succeeds, fails with something other than
+// ErrUnavailable or runs out of retries
+func retry(ctx context.Context, opts Options, id string, attempt func() error) error {
+	backoff := opts.RetryBackoff
+	for n := 0; ; n++ {
+		err := attempt()
+		if err == nil || !errors.Is(err, ErrUnavailable) || n >= opts.Retries {
+			return err
+		}
+
+		// Full jitter keeps a burst of callers from retrying in lockstep
+		select {
+		case <-ctx.Done():
+			return &Error{ID: id, Kind: ErrUnavailable, Err: ctx.Err()}
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff) + 1))):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *HTTPResolver) fetch(ctx context.Context, id string) (Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/services/"+url.PathEscape(id), nil)
+	if err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrUnavailable, Err: err}
+	}
+	if err := r.setHeaders(req); err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrUnauthorized, Err: err}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Service{}, &Error{ID: id, Kind: ErrUnavailable, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(id, resp); err != nil {
+		return Service{}, err
+	}
+
+	var service Service
+	if err := json.NewDecoder(resp.Body).Decode(&service); err != nil {
+		return Service{}, &Error{ID: id, StatusCode: resp.StatusCode, Kind: ErrUnavailable, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+	return service, nil
+}
+
+func (r *HTTPResolver) setHeaders(req *http.Request) error {
+	token := r.opts.Token
+	if r.token != nil {
+		var err error
+		if token, err = r.token.get(); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if r.opts.RoutingKey != "" {
+		req.Header.Set("X-Routing-Key", r.opts.RoutingKey)
+	}
+	if r.opts.CallerID != "" {
+		req.Header.Set("X-Caller-ID", r.opts.CallerID)
+	}
+	return nil
+}
+
+// fetchBatch asks the injector's batch endpoint for ids. The error is set
+// only if the request as a whole failed.
+func (r *HTTPResolver) fetchBatch(ctx context.Context, ids []string) (map[string]Service, map[string]error, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	joined := strings.Join(ids, ",")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/services?ids="+url.QueryEscape(joined), nil)
+	if err != nil {
+		return nil, nil, &Error{ID: joined, Kind: ErrUnavailable, Err: err}
+	}
+	if err := r.setHeaders(req); err != nil {
+		return nil, nil, &Error{ID: joined, Kind: ErrUnauthorized, Err: err}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, nil, &Error{ID: joined, Kind: ErrUnavailable, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(joined, resp); err != nil {
+		return nil, nil, err
+	}
+
+	var body struct {
+		Services map[string]Service `json:"services"`
+		Errors   map[string]string  `json:"errors"`
+		Statuses map[string]int     `json:"statuses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, nil, &Error{ID: joined, StatusCode: resp.StatusCode, Kind: ErrUnavailable, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+
+	missing := make(map[string]error, len(body.Errors))
+	for id, msg := range body.Errors {
+		// Each failed id comes with the status resolving it alone would
+		// have answered, older injectors only sent the message
+		status := body.Statuses[id]
+		if status == 0 && msg == "service not found" {
+			status = http.StatusNotFound
+		}
+		e := &Error{ID: id, StatusCode: status, Kind: statusKind(status)}
+		if e.Kind != ErrNotFound {
+			e.Err = errors.New(msg)
+		}
+		missing[id] = e
+	}
+	return body.Services, missing, nil
+}
+
+// statusError maps a non-200 injector response to an *Error
+func statusError(id string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(data, &body)
+	var cause error
+	if body.Error != "" {
+		cause = errors.New(body.Error)
+	}
+
+	return &Error{ID: id, StatusCode: resp.StatusCode, Kind: statusKind(resp.StatusCode), Err: cause}
+}
+
+// statusKind is the sentinel error for an injector status code. Only
+// ErrUnavailable is worth retrying.
+func statusKind(status int) error {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrUnauthorized
+	case status >= 400 && status < 500 && status != http.StatusTooManyRequests:
+		// Any other client error will not go away by retrying
+		return ErrRejected
+	default:
+		return ErrUnavailable
+	}
+}
+
+// forID copies a failure of a whole batch onto one of its ids
+func forID(err error, id string) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return &Error{ID: id, StatusCode: e.StatusCode, Kind: e.Kind, Err: e.Err}
+	}
+	return err
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func durationEnv(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return d
+	}
+	return def
+}
+
+func intEnv(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,49 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveBatchErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"services": {"hello": {"id": "hello", "ServiceName": "hello", "ServiceAddress": "http://hello"}},
+			"errors": {
+				"missing": "service not found",
+				"secret": "access denied",
+				"opa-down": "authorization unavailable",
+				"legacy": "failed to fetch service"
+			},
+			"statuses": {"missing": 404, "secret": 403, "opa-down": 503}
+		}`)
+	}))
+	defer srv.Close()
+
+	r := NewHTTPResolver(srv.URL, Options{})
+	services, failed := r.ResolveBatch(context.Background(), []string{"hello", "missing", "secret", "opa-down", "legacy"})
+	if _, ok := services["hello"]; !ok {
+		t.Fatal("hello not resolved")
+	}
+
+	tests := []struct {
+		id   string
+		want error
+	}{
+		{"missing", ErrNotFound},
+		{"secret", ErrUnauthorized},
+		{"opa-down", ErrUnavailable},
+		// Without a status the entry is taken as worth retrying
+		{"legacy", ErrUnavailable},
+	}
+	for _, tt := range tests {
+		if err := failed[tt.id]; !errors.Is(err, tt.want) {
+			t.Errorf("%s failed with %v, want %v", tt.id, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Descriptor attributes can hold a reference instead of a plaintext secret:
+//
+//	secret:file:/etc/secrets/minio/password   contents of a file
+//	secret:env:SECRET_MINIO_PASSWORD          an environment variable
+//	secret:k8s:minio-credentials/password     key of a Kubernetes Secret
+//
+// The Container replaces references with their values right before a
+// factory builds a client, so secrets never pass through the injector.
+// Anyone who can register a descriptor picks the references, so they are
+// confined to the secrets meant for functions: files below a secrets
+// directory and variables with a secrets prefix.
+const secretPrefix = "secret:"
+
+// Secret reference sources
+const (
+	SecretFile       = "file"
+	SecretEnv        = "env"
+	SecretKubernetes = "k8s"
+)
+
+var (
+	// ErrSecretNotFound is returned when a referenced secret does not exist
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrSecretForbidden is returned for a reference outside the secrets a
+	// provider hands out, e.g. a file outside its directory
+	ErrSecretForbidden = errors.New("secret reference not allowed")
+)
+
+// SecretRef is a parsed secret reference. For Kubernetes Secrets Name is
+// "secret-name/key".
+type SecretRef struct {
+	Source string
+	Name   string
+}
+
+func (r SecretRef) String() string {
+	return secretPrefix + r.Source + ":" + r.Name
+}
+
+// ParseSecretRef parses s if it is a secret reference
+func ParseSecretRef(s string) (SecretRef, bool) {
+	rest, ok := strings.CutPrefix(s, secretPrefix)
+	if !ok {
+		return SecretRef{}, false
+	}
+	source, name, ok := strings.Cut(rest, ":")
+	if !ok || name == "" {
+		return SecretRef{}, false
+	}
+	switch source {
+	case SecretFile, SecretEnv:
+	case SecretKubernetes:
+		if secret, key, ok := strings.Cut(name, "/"); !ok || secret == "" || key == "" {
+			return SecretRef{}, false
+		}
+	default:
+		return SecretRef{}, false
+	}
+	return SecretRef{Source: source, Name: name}, true
+}
+
+// SecretProvider looks up the value of a secret reference
+type SecretProvider interface {
+	Secret(ctx context.Context, ref SecretRef) (string, error)
+}
+
+// DefaultSecrets reads file references from below FileDir, env references
+// from the environment and Kubernetes Secrets from where they are mounted:
+// KubernetesDir/<secret>/<key>, /var/run/secrets/injector if empty. Mount
+// each Secret a function needs as a volume there.
+type DefaultSecrets struct {
+	// FileDir holds the files references may name, /etc/secrets if empty.
+	// Relative names are read below it, absolute ones must lie within it.
+	FileDir string
+	// EnvPrefix is the prefix of the variables references may name,
+	// SECRET_ if empty, so the rest of the environment stays private
+	EnvPrefix     string
+	KubernetesDir string
+}
+
+func (p DefaultSecrets) Secret(ctx context.Context, ref SecretRef) (string, error) {
+	switch ref.Source {
+	case SecretFile:
+		dir := p.FileDir
+		if dir == "" {
+			dir = "/etc/secrets"
+		}
+		path, err := secretPath(ref, dir, ref.Name)
+		if err != nil {
+			return "", err
+		}
+		return readSecretFile(ref, path)
+	case SecretEnv:
+		prefix := p.EnvPrefix
+		if prefix == "" {
+			prefix = "SECRET_"
+		}
+		if !strings.HasPrefix(ref.Name, prefix) {
+			return "", fmt.Errorf("%s: %w, variables must start with %s", ref, ErrSecretForbidden, prefix)
+		}
+		value, ok := os.LookupEnv(ref.Name)
+		if !ok {
+			return "", fmt.Errorf("%s: %w", ref, ErrSecretNotFound)
+		}
+		return value, nil
+	case SecretKubernetes:
+		dir := p.KubernetesDir
+		if dir == "" {
+			dir = "/var/run/secrets/injector"
+		}
+		path, err := secretPath(ref, dir, ref.Name)
+		if err != nil {
+			return "", err
+		}
+		return readSecretFile(ref, path)
+	default:
+		return "", fmt.Errorf("%s: unknown source", ref)
+	}
+}
+
+// FileSecrets resolves every reference from files below Dir, at
+// Dir/<source>/<name>, e.g. Dir/k8s/minio-credentials/password. It lets a
+// function run locally, or in tests, with the same descriptors as in the
+// cluster.
+type FileSecrets struct {
+	Dir string
+}
+
+func (p FileSecrets) Secret(ctx context.Context, ref SecretRef) (string, error) {
+	path, err := secretPath(ref, filepath.Join(p.Dir, ref.Source), strings.TrimPrefix(ref.Name, "/"))
+	if err != nil {
+		return "", err
+	}
+	return readSecretFile(ref, path)
+}
+
+// SecretsFromEnv returns DefaultSecrets reading files below
+// INJECTOR_SECRETS_DIR, variables prefixed INJECTOR_SECRETS_ENV_PREFIX and
+// Kubernetes Secrets from INJECTOR_K8S_SECRETS_DIR. With
+// INJECTOR_SECRETS_MODE=files it returns FileSecrets for
+// INJECTOR_SECRETS_DIR instead, to run a function locally.
+func SecretsFromEnv() SecretProvider {
+	dir := os.Getenv("INJECTOR_SECRETS_DIR")
+	if os.Getenv("INJECTOR_SECRETS_MODE") == "files" && dir != "" {
+		return FileSecrets{Dir: dir}
+	}
+	return DefaultSecrets{
+		FileDir:       dir,
+		EnvPrefix:     os.Getenv("INJECTOR_SECRETS_ENV_PREFIX"),
+		KubernetesDir: os.Getenv("INJECTOR_K8S_SECRETS_DIR"),
+	}
+}
+
+// ResolveSecrets returns service with every secret reference among its top
+// level attributes replaced by the secret's value
+func ResolveSecrets(ctx context.Context, provider SecretProvider, service Service) (Service, error) {
+	var resolved map[string]interface{}
+	for k, v := range service.Attributes {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		ref, ok := ParseSecretRef(str)
+		if !ok {
+			continue
+		}
+
+		value, err := provider.Secret(ctx, ref)
+		if err != nil {
+			return Service{}, fmt.Errorf("resolving %q: attribute %s: %w", service.ID, k, err)
+		}
+		if resolved == nil {
+			// Copy on first write, the descriptor may be shared with a cache
+			resolved = make(map[string]interface{}, len(service.Attributes))
+			for k, v := range service.Attributes {
+				resolved[k] = v
+			}
+		}
+		resolved[k] = value
+	}
+
+	if resolved != nil {
+		service.Attributes = resolved
+	}
+	return service, nil
+}
+
+// secretPath is the file name refers to below dir. Absolute names are
+// taken as they are, either way the file must lie within dir.
+func secretPath(ref SecretRef, dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	path := name
+	if !filepath.IsAbs(name) {
+		path = filepath.Join(dir, name)
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w, it must name a file below %s", ref, ErrSecretForbidden, dir)
+	}
+	return path, nil
+}
+
+// readSecretFile reads a secret file, dropping the trailing newline most
+// tools add when writing one
+func readSecretFile(ref SecretRef, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s: %w", ref, ErrSecretNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,99 @@
+package injectorsdk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSecret(t *testing.T, path, value string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(value+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "secrets")
+	writeSecret(t, filepath.Join(dir, "file", "etc", "minio", "password"), "file-value")
+	writeSecret(t, filepath.Join(dir, "env", "SECRET_TOKEN"), "env-value")
+	writeSecret(t, filepath.Join(dir, "k8s", "minio-credentials", "user"), "k8s-value")
+	writeSecret(t, filepath.Join(root, "outside"), "leaked")
+
+	p := FileSecrets{Dir: dir}
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"secret:file:/etc/minio/password", "file-value", nil},
+		{"secret:env:SECRET_TOKEN", "env-value", nil},
+		{"secret:k8s:minio-credentials/user", "k8s-value", nil},
+		{"secret:k8s:minio-credentials/missing", "", ErrSecretNotFound},
+		{"secret:file:../../outside", "", ErrSecretForbidden},
+		{"secret:env:../../outside", "", ErrSecretForbidden},
+		{"secret:k8s:../../outside", "", ErrSecretForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			ref, ok := ParseSecretRef(tt.ref)
+			if !ok {
+				t.Fatalf("%q does not parse", tt.ref)
+			}
+			got, err := p.Secret(context.Background(), ref)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultSecretsConfined(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "secrets")
+	writeSecret(t, filepath.Join(dir, "minio", "password"), "file-value")
+	writeSecret(t, filepath.Join(root, "outside"), "leaked")
+	t.Setenv("SECRET_TOKEN", "env-value")
+	t.Setenv("HOME_TOKEN", "private")
+
+	p := DefaultSecrets{FileDir: dir}
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"secret:file:minio/password", "file-value", nil},
+		{"secret:file:" + filepath.Join(dir, "minio", "password"), "file-value", nil},
+		{"secret:file:minio/../minio/password", "file-value", nil},
+		{"secret:file:../outside", "", ErrSecretForbidden},
+		{"secret:file:" + filepath.Join(root, "outside"), "", ErrSecretForbidden},
+		{"secret:file:/etc/passwd", "", ErrSecretForbidden},
+		{"secret:env:SECRET_TOKEN", "env-value", nil},
+		{"secret:env:SECRET_MISSING", "", ErrSecretNotFound},
+		{"secret:env:HOME_TOKEN", "", ErrSecretForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			ref, ok := ParseSecretRef(tt.ref)
+			if !ok {
+				t.Fatalf("%q does not parse", tt.ref)
+			}
+			got, err := p.Secret(context.Background(), ref)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package injectorsdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Service is a descriptor as served by the injector. Binding specific
+// attributes (e.g. Admin, Password and Bucket for MinIO) sit next to the
+// fixed fields in the JSON object and end up in Attributes.
+type Service struct {
+	ID             string
+	ServiceName    string
+	ServiceAddress string
+	Kind           string
+	Revision       int64
+	// Endpoints are replicas of the service to spread calls over with a
+	// Balancer, using Strategy. ServiceAddress is the one the injector picked.
+	Endpoints []string
+	Strategy  string
+	// Variant is the backend the injector picked when the service splits
+	// its traffic between several, empty otherwise
+	Variant string
+	// Expires is when the credentials in the descriptor stop working, for
+	// credentials the injector minted just for this caller
+	Expires    time.Time
+	Attributes map[string]interface{}
+}
+
+func (s Service) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]interface{}, len(s.Attributes)+5)
+	for k, v := range s.Attributes {
+		obj[k] = v
+	}
+	obj["id"] = s.ID
+	obj["ServiceName"] = s.ServiceName
+	obj["ServiceAddress"] = s.ServiceAddress
+	if s.Kind != "" {
+		obj["Kind"] = s.Kind
+	}
+	if s.Revision != 0 {
+		obj["Revision"] = s.Revision
+	}
+	if len(s.Endpoints) > 0 {
+		obj["Endpoints"] = s.Endpoints
+	}
+	if s.Strategy != "" {
+		obj["Strategy"] = s.Strategy
+	}
+	if s.Variant != "" {
+		obj["Variant"] = s.Variant
+	}
+	if !s.Expires.IsZero() {
+		obj["Expires"] = s.Expires.Format(time.RFC3339)
+	}
+	return json.Marshal(obj)
+}
+
+func (s *Service) UnmarshalJSON(data []byte) error {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+
+	*s = Service{Attributes: make(map[string]interface{})}
+	for k, v := range obj {
+		switch k {
+		case "id":
+			s.ID, _ = v.(string)
+		case "ServiceName":
+			s.ServiceName, _ = v.(string)
+		case "ServiceAddress":
+			s.ServiceAddress, _ = v.(string)
+		case "Kind":
+			s.Kind, _ = v.(string)
+		case "Revision":
+			rev, _ := v.(float64)
+			s.Revision = int64(rev)
+		case "Endpoints":
+			items, _ := v.([]interface{})
+			for _, item := range items {
+				if endpoint, ok := item.(string); ok {
+					s.Endpoints = append(s.Endpoints, endpoint)
+				}
+			}
+		case "Strategy":
+			s.Strategy, _ = v.(string)
+		case "Variant":
+			s.Variant, _ = v.(string)
+		case "Expires":
+			str, _ := v.(string)
+			s.Expires, _ = time.Parse(time.RFC3339, str)
+		default:
+			s.Attributes[k] = v
+		}
+	}
+	return nil
+}
+
+// credentialRefreshMargin is how long before their expiry minted
+// credentials are replaced
+const credentialRefreshMargin = time.Minute
+
+// fresh reports whether the descriptor's credentials, if any, are still
+// good for a while
+func (s Service) fresh() bool {
+	return s.Expires.IsZero() || time.Until(s.Expires) > credentialRefreshMargin
+}
+
+// String returns attribute key as a string, or "" if it is missing
+func (s Service) String(key string) string {
+	switch v := s.Attributes[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Decode fills out, typically a caller specific struct such as
+// struct{ Admin, Password, Bucket string }, from the descriptor's JSON form
+func (s Service) Decode(out interface{}) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,53 @@
+package injectorsdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// certCheckInterval bounds how often a client certificate's files are
+// checked for changes
+const certCheckInterval = 10 * time.Second
+
+// LoadClientTLS returns the TLS configuration for an https:// injector. caFile
+// is the CA the injector's certificate is checked against, the system pool
+// if empty. certFile and keyFile, if set, are presented as client
+// certificate in mTLS mode and re-read when they change, so a rotated
+// certificate is picked up by a warm function instance.
+func LoadClientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := NewReloader(certCheckInterval, func() (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			return &cert, err
+		}, certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			// On failure, e.g. mid-rotation, the previous certificate is kept
+			c, _ := cert.Get()
+			return c, nil
+		}
+	}
+	return config, nil
+}
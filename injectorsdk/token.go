@@ -0,0 +1,42 @@
+package injectorsdk
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshInterval is how long a token read from a file is used before
+// the file is read again. Projected service account tokens are rotated by
+// the kubelet well before they expire.
+const tokenRefreshInterval = time.Minute
+
+// tokenFile reads a bearer token from a file that may be replaced at any time
+type tokenFile struct {
+	path string
+
+	mu    sync.Mutex
+	token string
+	read  time.Time
+}
+
+func (t *tokenFile) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Since(t.read) < tokenRefreshInterval {
+		return t.token, nil
+	}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		if t.token != "" {
+			// Mid-rotation, the previous token is likely still valid
+			return t.token, nil
+		}
+		return "", fmt.Errorf("reading token: %w", err)
+	}
+	t.token, t.read = strings.TrimSpace(string(data)), time.Now()
+	return t.token, nil
+}
@@ -0,0 +1,94 @@
+package injectorsdk
+
+import (
+	"hash/fnv"
+	"math/rand"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Variant is one backend of a service whose traffic is split by weight, e.g.
+// 90 to v1 and 10 to v2 for a canary rollout. The injector stores them in
+// the registry's Variants key.
+type Variant struct {
+	Name           string `json:"Name" bson:"Name"`
+	ServiceAddress string `json:"ServiceAddress" bson:"ServiceAddress"`
+	Weight         int64  `json:"Weight" bson:"Weight"`
+}
+
+// variantsFromDocument reads the Variants of a registry document
+func variantsFromDocument(v interface{}) []Variant {
+	items, _ := v.(bson.A)
+	var variants []Variant
+	for _, item := range items {
+		doc, ok := item.(bson.M)
+		if !ok {
+			continue
+		}
+		var vr Variant
+		vr.Name, _ = doc["Name"].(string)
+		vr.ServiceAddress, _ = doc["ServiceAddress"].(string)
+		switch w := doc["Weight"].(type) {
+		case int32:
+			vr.Weight = int64(w)
+		case int64:
+			vr.Weight = w
+		case float64:
+			vr.Weight = int64(w)
+		}
+		variants = append(variants, vr)
+	}
+	return variants
+}
+
+// PickVariant chooses one of the variants of the service id by weight. A
+// non-empty key always picks the same variant while the weights stay the
+// same, so a caller can stick to one version. Both the injector and the sdk
+// mode pick through here, so a key sticks to the same variant in every
+// resolution mode. Variants with a negative weight are never picked and
+// false is returned if no weight is positive.
+func PickVariant(id string, variants []Variant, key string) (Variant, bool) {
+	var total int64
+	for _, v := range variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total <= 0 {
+		return Variant{}, false
+	}
+
+	var n int64
+	if key != "" {
+		h := fnv.New64a()
+		h.Write([]byte(id + "\x00" + key))
+		n = int64(h.Sum64() % uint64(total))
+	} else {
+		n = rand.Int63n(total)
+	}
+
+	for _, v := range variants {
+		if v.Weight <= 0 {
+			continue
+		}
+		if n < v.Weight {
+			return v, true
+		}
+		n -= v.Weight
+	}
+	return Variant{}, false
+}
+
+// pickVariant serves the variant PickVariant chooses in place of service,
+// or service as is without one
+func pickVariant(service Service, variants []Variant, key string) Service {
+	picked, ok := PickVariant(service.ID, variants, key)
+	if !ok {
+		return service
+	}
+	service.ServiceAddress = picked.ServiceAddress
+	service.Variant = picked.Name
+	// The endpoints are replicas of the base service, not of the variant
+	service.Endpoints = nil
+	return service
+}
@@ -0,0 +1,88 @@
+package injectorsdk
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDocumentVariants(t *testing.T) {
+	doc := documentFrom(bson.M{
+		"_id":            "65f0c0ffee",
+		"id":             "hello",
+		"ServiceName":    "hello",
+		"ServiceAddress": "http://hello",
+		"Endpoints":      bson.A{"http://hello-0", "http://hello-1"},
+		"Variants": bson.A{
+			bson.M{"Name": "stable", "ServiceAddress": "http://hello-v1", "Weight": int32(0)},
+			bson.M{"Name": "canary", "ServiceAddress": "http://hello-v2", "Weight": int64(10)},
+		},
+		"Region": "eu",
+	})
+	if len(doc.variants) != 2 {
+		t.Fatalf("got %d variants, want 2", len(doc.variants))
+	}
+	if _, ok := doc.service.Attributes["Variants"]; ok {
+		t.Fatal("Variants left among the attributes")
+	}
+	if _, ok := doc.service.Attributes["_id"]; ok {
+		t.Fatal("_id left among the attributes")
+	}
+
+	service := pickVariant(doc.service, doc.variants, "caller-1")
+	if service.Variant != "canary" || service.ServiceAddress != "http://hello-v2" {
+		t.Fatalf("picked %q at %s, want canary, the only variant with weight", service.Variant, service.ServiceAddress)
+	}
+	if len(service.Endpoints) != 0 {
+		t.Fatalf("variant kept the base endpoints %v", service.Endpoints)
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []Variant
+		want     []string // variants that may be picked, "" for none
+	}{
+		{"none", nil, []string{""}},
+		{"all zero", []Variant{{"a", "http://a", 0}, {"b", "http://b", 0}}, []string{""}},
+		{"negative ignored", []Variant{{"a", "http://a", -5}, {"b", "http://b", 1}}, []string{"b"}},
+		{"all negative", []Variant{{"a", "http://a", -1}}, []string{""}},
+		{"split", []Variant{{"a", "http://a", 1}, {"b", "http://b", 1}}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				for _, key := range []string{"", "caller-1"} {
+					got, ok := PickVariant("hello", tt.variants, key)
+					if !slices.Contains(tt.want, got.Name) || ok != (got.Name != "") {
+						t.Fatalf("picked %q, %v for key %q, want one of %q", got.Name, ok, key, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPickVariantSticky(t *testing.T) {
+	variants := []Variant{{"a", "http://a", 50}, {"b", "http://b", 50}}
+
+	first, _ := PickVariant("hello", variants, "caller-1")
+	for i := 0; i < 20; i++ {
+		if got, _ := PickVariant("hello", variants, "caller-1"); got != first {
+			t.Fatalf("routing key moved from %q to %q", first.Name, got.Name)
+		}
+	}
+
+	// Keys spread over the variants by weight
+	picked := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		v, _ := PickVariant("hello", variants, fmt.Sprint("caller-", i))
+		picked[v.Name]++
+	}
+	if picked["a"] < 400 || picked["b"] < 400 {
+		t.Fatalf("keys split %v over two equal variants", picked)
+	}
+}